@@ -2,17 +2,23 @@ package main
 
 import (
 	"atlasq/internal/database"
+	"atlasq/internal/logger"
 	"atlasq/internal/migration"
 	"log"
 	"os"
 
 	_ "github.com/golang-migrate/migrate/v4/database/postgres" // <-- เพิ่มบรรทัดนี้!
-	_ "github.com/golang-migrate/migrate/v4/source/file"       // <-- สำคัญ!
+	"go.uber.org/zap"
 )
 
 func main() {
+	if err := logger.Init("atlasq-migrator", logger.ComponentMigrator); err != nil {
+		log.Fatalf("failed to init logger: %v", err)
+	}
+	defer logger.L().Sync()
+
 	if len(os.Args) < 2 {
-		log.Fatalf("usage: go run cmd/migrate.go up|down")
+		logger.L().Fatal("usage: go run ./cmd/migrator up|down")
 	}
 
 	migrate := &migration.Migrate{
@@ -22,15 +28,15 @@ func main() {
 	switch os.Args[1] {
 	case "up":
 		if err := migrate.MigrateUp(); err != nil {
-			log.Fatalf("migration up failed: %v", err)
+			logger.L().Fatal("migration up failed", zap.Error(err))
 		}
-		log.Println("migration up success")
+		logger.L().Info("migration up success")
 	case "down":
 		if err := migrate.MigrateDown(); err != nil {
-			log.Fatalf("migration down failed: %v", err)
+			logger.L().Fatal("migration down failed", zap.Error(err))
 		}
-		log.Println("migration down success")
+		logger.L().Info("migration down success")
 	default:
-		log.Fatalf("unknown command: %s", os.Args[1])
+		logger.L().Fatal("unknown command", zap.String("command", os.Args[1]))
 	}
 }
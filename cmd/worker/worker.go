@@ -3,27 +3,61 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
+	"atlasq/internal/admin"
+	"atlasq/internal/correlation"
 	"atlasq/internal/database"
+	"atlasq/internal/health"
+	"atlasq/internal/inventory"
+	"atlasq/internal/logger"
+	"atlasq/internal/metrics"
+	"atlasq/internal/numeric"
 	tasks "atlasq/internal/tasks"
+	"atlasq/internal/tracing"
+	"atlasq/internal/webhook"
 
 	"github.com/hibiken/asynq"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
 )
 
-type OrderItem struct {
-	ProductID int64 `json:"product_id"`
-	Quantity  int64 `json:"quantity"`
-}
-
+// pool is shared by every task handler.
 var pool *pgxpool.Pool
 
+// httpAddr is where the worker serves /metrics, /healthz, /readyz and
+// /admin/log-level.
+const httpAddr = ":8081"
+
 func main() {
+	if err := logger.Init("atlasq-worker", logger.ComponentWorker); err != nil {
+		log.Fatalf("failed to init logger: %v", err)
+	}
+	defer logger.L().Sync()
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{Service: "atlasq-worker"})
+	if err != nil {
+		logger.L().Fatal("failed to init tracing", zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
+
+	db := &database.PostgreSQL{}
+	pool, err = db.Connect()
+	if err != nil {
+		logger.L().Fatal("failed to connect to PostgreSQL", zap.Error(err))
+	}
+	defer pool.Close()
+	if err := metrics.RegisterPool(pool); err != nil {
+		logger.L().Fatal("failed to register pool metrics", zap.Error(err))
+	}
+
 	srv := asynq.NewServer(
 		asynq.RedisClientOpt{Addr: "127.0.0.1:6379"},
 		asynq.Config{
@@ -36,42 +70,106 @@ func main() {
 	)
 
 	mux := asynq.NewServeMux()
+	mux.Use(tracing.TaskMiddleware, metrics.TaskMiddleware)
 	mux.HandleFunc("order:deduct_stock", DeductStockTaskHandler)
+	mux.HandleFunc("lot:flag_expiring", FlagExpiringLotsTaskHandler)
+	mux.HandleFunc("alert:deliver_low_stock", DeliverLowStockAlertsTaskHandler)
+
+	// periodic jobs, both are idempotent so several workers may schedule them
+	scheduler := asynq.NewScheduler(asynq.RedisClientOpt{Addr: "127.0.0.1:6379"}, nil)
+	if _, err := scheduler.Register("@daily", asynq.NewTask("lot:flag_expiring", nil)); err != nil {
+		logger.L().Fatal("could not register expiry job", zap.Error(err))
+	}
+	// alerts are written with the stock change and delivered from here, a
+	// failed webhook is tried again on the next run
+	if _, err := scheduler.Register("@every 1m", asynq.NewTask("alert:deliver_low_stock", nil), asynq.Unique(time.Minute)); err != nil {
+		logger.L().Fatal("could not register alert delivery job", zap.Error(err))
+	}
+	if err := scheduler.Start(); err != nil {
+		logger.L().Fatal("could not start scheduler", zap.Error(err))
+	}
+	defer scheduler.Shutdown()
+
+	// a side port for Prometheus and the orchestrator's probes
+	probes := health.Handler([]health.Check{
+		health.Postgres(pool),
+		health.Redis(srv),
+		health.Schema(pool),
+	})
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/metrics", metrics.Handler())
+	httpMux.Handle("/healthz", probes)
+	httpMux.Handle("/readyz", probes)
+	httpMux.Handle("/admin/log-level", admin.RequireToken(logger.LevelHandler()))
+	go func() {
+		if err := http.ListenAndServe(httpAddr, httpMux); err != nil {
+			logger.L().Fatal("could not run HTTP server", zap.Error(err))
+		}
+	}()
 
 	if err := srv.Run(mux); err != nil {
-		log.Fatalf("could not run server: %v", err)
+		logger.L().Fatal("could not run server", zap.Error(err))
 	}
 }
 
 // ----------------- Handler -----------------
 
 func DeductStockTaskHandler(ctx context.Context, t *asynq.Task) error {
-	log.Printf("Printf func DeductStockTaskHandler")
 	var payload tasks.DeductStockPayload
 	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
 		return fmt.Errorf("failed to unmarshal payload: %w", err)
 	}
-
-	db := &database.PostgreSQL{}
-	pool, err := db.Connect()
-	if err != nil {
-		log.Printf("failed to connect DB: %v", err)
-		return err
-	}
-	defer pool.Close()
+	// the ledger rows written below carry the request ID through ctx, and
+	// the serials reserved for this order may only be sold by this task
+	ctx = correlation.WithID(ctx, payload.RequestID)
+	ctx = inventory.WithReservation(ctx, payload.ReservationID)
+	log := taskLogger(ctx, t).With(zap.Int64("tenant_id", payload.TenantID))
+	log.Debug("deducting stock", zap.Int64("warehouse_id", payload.WarehouseID), zap.Int("items", len(payload.Items)))
 
 	conn, err := pool.Acquire(ctx)
 	if err != nil {
-		log.Printf("failed to acquire DB connection: %v", err)
+		log.Error("failed to acquire DB connection", zap.Error(err))
 		return err
 	}
 	defer conn.Release()
 
+	err = deductStock(ctx, log, conn, payload)
+	final := err != nil && finalAttempt(ctx, err)
+	// an order short of stock is retried, it only counts as rejected once
+	// asynq gives up on it
+	var stockErr *inventory.InsufficientStockError
+	if final && errors.As(err, &stockErr) {
+		metrics.Rejected(payload.TenantID)
+	}
+	// whatever the order did not sell stays reserved until released: the
+	// serials beyond a partial shipment, or all of them once it gave up
+	if payload.ReservationID != "" && (err == nil || final) {
+		if releaseErr := inventory.ReleaseSerials(ctx, conn, payload.TenantID, payload.ReservationID); releaseErr != nil {
+			log.Error("failed to release serials", zap.Error(releaseErr))
+		}
+	}
+	return err
+}
+
+// finalAttempt reports whether asynq will not run the task again after err.
+func finalAttempt(ctx context.Context, err error) bool {
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	return isFinal(retried, maxRetry, err)
+}
+
+func isFinal(retried, maxRetry int, err error) bool {
+	return errors.Is(err, asynq.SkipRetry) || retried >= maxRetry
+}
+
+// deductStock runs the order in a serializable transaction, retrying
+// serialization conflicts in place.
+func deductStock(ctx context.Context, log *zap.Logger, conn *pgxpool.Conn, payload tasks.DeductStockPayload) error {
 	const maxRetries = 5
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
 		if err != nil {
-			log.Printf("failed to begin tx: %v", err)
+			log.Error("failed to begin tx", zap.Error(err))
 			return err
 		}
 
@@ -84,23 +182,38 @@ func DeductStockTaskHandler(ctx context.Context, t *asynq.Task) error {
 		}()
 
 		// ---- business logic ----
-		processErr := processStockTx(ctx, tx, payload)
+		deducted, processErr := processStockTx(ctx, log, tx, payload)
 		if processErr != nil {
 			// ถ้าเจอ serialization conflict → retry
-			if pgErr, ok := processErr.(*pgconn.PgError); ok && pgErr.Code == "40001" {
-				log.Printf("serialization failure (retry %d/%d)", attempt, maxRetries)
+			var pgErr *pgconn.PgError
+			if errors.As(processErr, &pgErr) && pgErr.Code == "40001" {
+				metrics.SerializationRetries.WithLabelValues("process").Inc()
+				log.Warn("serialization failure", zap.Int("attempt", attempt), zap.Int("max_retries", maxRetries))
 				_ = tx.Rollback(ctx)
 				time.Sleep(time.Duration(attempt) * 1000 * time.Millisecond) // backoff
 				continue
 			}
 			_ = tx.Rollback(ctx)
+			// an unknown product, a parent product, a bad unit, bad serials or stock left only in expired lots will not change by retrying
+			var productErr *inventory.ProductNotFoundError
+			var variantErr *inventory.VariantRequiredError
+			var unitErr *inventory.UnknownUnitError
+			var serialErr *inventory.SerialError
+			if errors.As(processErr, &productErr) || errors.As(processErr, &variantErr) ||
+				errors.As(processErr, &unitErr) || errors.As(processErr, &serialErr) ||
+				errors.Is(processErr, numeric.ErrTooPrecise) || errors.Is(processErr, inventory.ErrLotsExpired) {
+				log.Warn("order rejected", zap.Error(processErr))
+				return fmt.Errorf("%v: %w", processErr, asynq.SkipRetry)
+			}
 			return processErr
 		}
 
 		// commit ถ้าไม่มี error
 		if err := tx.Commit(ctx); err != nil {
-			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "40001" {
-				log.Printf("commit failed due to serialization (retry %d/%d)", attempt, maxRetries)
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "40001" {
+				metrics.SerializationRetries.WithLabelValues("commit").Inc()
+				log.Warn("commit failed due to serialization", zap.Int("attempt", attempt), zap.Int("max_retries", maxRetries))
 				time.Sleep(time.Duration(attempt) * 1000 * time.Millisecond)
 				continue
 			}
@@ -108,95 +221,89 @@ func DeductStockTaskHandler(ctx context.Context, t *asynq.Task) error {
 		}
 
 		rollback = false // commit สำเร็จแล้ว → ไม่ต้อง rollback
-		log.Printf("✅ Order processed: tenant=%d warehouse=%d items=%d",
-			payload.TenantID, payload.WarehouseID, len(payload.Items))
+		metrics.Deducted(payload.TenantID, deducted)
+		log.Info("order processed", zap.Int64("warehouse_id", payload.WarehouseID), zap.Int("items", len(payload.Items)))
 		return nil
 	}
 
 	return fmt.Errorf("failed after %d retries due to serialization conflicts", maxRetries)
 }
 
-// แยก logic ออกมาเพื่อให้อ่านง่าย
-func processStockTx(ctx context.Context, tx pgx.Tx, payload tasks.DeductStockPayload) error {
-	log.Printf("processStockTx 1")
-	for _, item := range payload.Items {
-		var stockID int64
-		var stockQty, reserveQty, onHandQty float64
-
-		// Query stock
-		err := tx.QueryRow(
-			ctx,
-			`SELECT id, quantity, reserve, on_hand 
-            FROM stock 
-            WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3`,
-			item.ProductID, payload.WarehouseID, payload.TenantID,
-		).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
-		log.Printf("processStockTx 1111")
-		if err != nil {
-			// insert ถ้ายังไม่มี stock
-			err = tx.QueryRow(
-				ctx,
-				`INSERT INTO stock (
-                    tenant_id, warehouse_id, product_id, minimum,
-                    quantity, reserve, on_hand, status,
-                    create_date, update_date, row_create_date, row_update_date
-                ) VALUES ($1,$2,$3,0,$4,0,$4,true,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
-                RETURNING id, quantity, reserve, on_hand`,
-				payload.TenantID, payload.WarehouseID, item.ProductID, float64(item.Quantity),
-			).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
-			if err != nil {
-				log.Printf("failed to insert stock: %w", err)
-				return fmt.Errorf("failed to insert stock: %w", err)
-			}
-		}
-		log.Printf("processStockTx 2222")
+// FlagExpiringLotsTaskHandler flags lots nearing their expiry date so they
+// show up in GET /api/v1/lots?expiring=true.
+func FlagExpiringLotsTaskHandler(ctx context.Context, t *asynq.Task) error {
+	log := taskLogger(ctx, t)
+	flagged, err := inventory.FlagExpiringLots(ctx, pool)
+	if err != nil {
+		return err
+	}
+	log.Info("expiring lots flagged", zap.Int64("flagged", flagged), zap.Int("warning_days", inventory.ExpiryWarningDays))
+	return nil
+}
+
+// alertBatch is how many alerts one delivery run claims.
+const alertBatch = 100
 
-		if stockQty < float64(item.Quantity) {
-			log.Printf("not enough stock for product_id=%d", item.ProductID)
-			return fmt.Errorf("not enough stock for product_id=%d , stockQty=%f , item.required=%d", item.ProductID, stockQty, item.Quantity)
+// DeliverLowStockAlertsTaskHandler posts undelivered low-stock alerts to
+// their tenant's callback_url. Runs may overlap, each only posts the alerts
+// it claimed.
+func DeliverLowStockAlertsTaskHandler(ctx context.Context, t *asynq.Task) error {
+	log := taskLogger(ctx, t)
+	// the claim outlasts the slowest run, which the deadline enforces
+	lease := alertBatch*webhook.Client.Timeout + time.Minute
+	ctx, cancel := context.WithTimeout(ctx, lease)
+	defer cancel()
+	alerts, err := inventory.ClaimAlerts(ctx, pool, alertBatch, lease)
+	if err != nil {
+		return err
+	}
+	delivered := 0
+	for _, a := range alerts {
+		sendErr := webhook.Send(ctx, a.CallbackURL, a.Secret, webhook.Event{Type: "stock.low", Data: a.StockAlert})
+		if sendErr != nil {
+			log.Warn("low-stock alert not delivered", zap.Int64("alert_id", a.ID), zap.Int64("tenant_id", a.TenantID), zap.Error(sendErr))
+		} else {
+			delivered++
 		}
-		log.Printf("processStockTx 4444")
-		// เช็ค stock พอไหม
-		newQty := stockQty - float64(item.Quantity)
-		log.Printf("processStockTx 5555")
-		// update stock
-		_, err = tx.Exec(
-			ctx,
-			`UPDATE stock 
-             SET quantity=$1, on_hand=$1, update_date=CURRENT_TIMESTAMP, row_update_date=CURRENT_TIMESTAMP 
-             WHERE id=$2`,
-			newQty, stockID,
-		)
-		log.Printf("processStockTx 6666")
-		if err != nil {
-			log.Printf("failed to update stock: %w", err)
-			return fmt.Errorf("failed to update stock: %w", err)
+		if err := inventory.RecordAlertDelivery(ctx, pool, a, sendErr == nil); err != nil {
+			return err
 		}
+	}
+	if len(alerts) > 0 {
+		log.Info("low-stock alerts delivered", zap.Int("delivered", delivered), zap.Int("pending", len(alerts)))
+	}
+	return nil
+}
+
+// taskLogger tags logger.L() with the task being processed and the request
+// that enqueued it, if any.
+func taskLogger(ctx context.Context, t *asynq.Task) *zap.Logger {
+	taskID, _ := asynq.GetTaskID(ctx)
+	queue, _ := asynq.GetQueueName(ctx)
+	return logger.WithJob(taskID, queue, t.Type(), correlation.ID(ctx))
+}
 
-		// insert transaction log
-		_, err = tx.Exec(
-			ctx,
-			`INSERT INTO transaction (
-                model,event,teanant_id,product_id,warehouse_id,stock_id,
-                quantity_old,quantity_change,quantity_new,
-                reserve_old,reserve_change,reserve_new,
-                on_hand_old,on_hand_change,on_hand_new,
-                status,create_date,update_date,row_create_date,row_update_date
-            ) VALUES (
-                $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,
-                CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP
-            )`,
-			"ORDER", "ISSUE", payload.TenantID, item.ProductID, payload.WarehouseID, stockID,
-			stockQty, -float64(item.Quantity), newQty,
-			reserveQty, 0, reserveQty,
-			onHandQty, -float64(item.Quantity), newQty,
-			true,
+// แยก logic ออกมาเพื่อให้อ่านง่าย
+// It returns the units deducted.
+func processStockTx(ctx context.Context, log *zap.Logger, tx pgx.Tx, payload tasks.DeductStockPayload) (decimal.Decimal, error) {
+	result, err := inventory.FulfillOrder(ctx, tx, payload.TenantID, payload.WarehouseID, payload.Items, payload.FulfillmentMode)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if result.Allocation != nil {
+		log.Info("order allocated",
+			zap.Int64("allocation_id", result.Allocation.ID),
+			zap.String("policy", result.Allocation.Policy),
+			zap.Int("warehouses", len(result.Allocation.Fulfillments)),
 		)
-		log.Printf("###### finish insert transaction stockID=%d ######", stockID)
-		if err != nil {
-			log.Printf("failed to insert transaction: %w", err)
-			return fmt.Errorf("failed to insert transaction: %w", err)
-		}
 	}
-	return nil
+	for _, s := range result.Shortages {
+		log.Warn("order line short",
+			zap.Int64("product_id", s.ProductID),
+			zap.Stringer("required", s.Required),
+			zap.Stringer("shipped", s.Shipped),
+			zap.Int64("backorder_id", s.BackorderID),
+		)
+	}
+	return result.Deducted, nil
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"atlasq/internal/inventory"
+
+	"github.com/hibiken/asynq"
+)
+
+func TestIsFinal(t *testing.T) {
+	stockErr := &inventory.InsufficientStockError{ProductID: 1}
+	tests := []struct {
+		name     string
+		retried  int
+		maxRetry int
+		err      error
+		want     bool
+	}{
+		{"first attempt", 0, 25, stockErr, false},
+		{"retries left", 24, 25, stockErr, false},
+		{"out of retries", 25, 25, stockErr, true},
+		{"no retries configured", 0, 0, errors.New("boom"), true},
+		{"skip retry", 0, 25, fmt.Errorf("bad serials: %w", asynq.SkipRetry), true},
+		{"serialization conflicts on the last run", 25, 25, errors.New("failed after 5 retries due to serialization conflicts"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFinal(tt.retried, tt.maxRetry, tt.err); got != tt.want {
+				t.Errorf("isFinal(%d, %d, %v) = %v, want %v", tt.retried, tt.maxRetry, tt.err, got, tt.want)
+			}
+		})
+	}
+}
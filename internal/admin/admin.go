@@ -0,0 +1,97 @@
+// Package admin guards the operator endpoints of the API and the worker.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+
+	"atlasq/internal/apierr"
+)
+
+// Roles of basic auth users. Viewers only get read-only tools.
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
+// TokenEnv names the bearer token admin endpoints require. Without it they
+// answer 404, so they are off unless configured.
+const TokenEnv = "ADMIN_TOKEN"
+
+// Authorized reports whether an Authorization header carries the admin
+// token. It is false while no token is configured.
+func Authorized(authorization string) bool {
+	token := os.Getenv(TokenEnv)
+	if token == "" {
+		return false
+	}
+	given, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Enabled reports whether an admin token is configured.
+func Enabled() bool {
+	return os.Getenv(TokenEnv) != ""
+}
+
+// RequireToken serves next only to requests carrying the admin token.
+func RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			apierr.Write(w, apierr.NotFound("not found"))
+			return
+		}
+		if !Authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="atlasq-admin"`)
+			apierr.Write(w, apierr.Unauthorized("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BasicEnabled reports whether admin basic auth credentials are configured
+// in ADMIN_USER and ADMIN_PASSWORD.
+func BasicEnabled() bool {
+	return os.Getenv("ADMIN_USER") != "" && os.Getenv("ADMIN_PASSWORD") != ""
+}
+
+// CheckBasic checks the credentials of a basic Authorization header against
+// ADMIN_USER/ADMIN_PASSWORD, then the optional read-only
+// MONITOR_VIEWER_USER/MONITOR_VIEWER_PASSWORD, and returns the user and
+// role.
+func CheckBasic(authorization string) (user, role string, ok bool) {
+	encoded, ok := strings.CutPrefix(authorization, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return "", "", false
+	}
+	if matches(user, pass, "ADMIN_USER", "ADMIN_PASSWORD") {
+		return user, RoleAdmin, true
+	}
+	if matches(user, pass, "MONITOR_VIEWER_USER", "MONITOR_VIEWER_PASSWORD") {
+		return user, RoleViewer, true
+	}
+	return "", "", false
+}
+
+func matches(user, pass, userEnv, passEnv string) bool {
+	wantUser, wantPass := os.Getenv(userEnv), os.Getenv(passEnv)
+	if wantUser == "" || wantPass == "" {
+		return false
+	}
+	// both compared every time so a wrong user takes as long as a wrong password
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(wantPass)) == 1
+	return userOK && passOK
+}
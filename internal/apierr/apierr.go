@@ -0,0 +1,126 @@
+// Package apierr is the error model of the HTTP API. Every error response
+// has the same envelope:
+//
+//	{"error": {"code": "not_found", "message": "product not found", "details": {...}, "request_id": "..."}}
+//
+// Code is stable and meant for machines, Message is safe to show to users.
+// The underlying cause is only logged, never returned.
+package apierr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Codes shared by all endpoints. Domain codes, such as insufficient_stock,
+// are defined next to the handlers that return them.
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeInternal       = "internal_error"
+	CodeUnavailable    = "unavailable"
+)
+
+// Error is an API error with the HTTP status it is answered with.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]any
+	// Cause is logged by the error handler and never sent to the client.
+	Cause error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// With returns a copy of e with key set in its details.
+func (e *Error) With(key string, value any) *Error {
+	out := *e
+	out.Details = make(map[string]any, len(e.Details)+1)
+	for k, v := range e.Details {
+		out.Details[k] = v
+	}
+	out.Details[key] = value
+	return &out
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// BadRequest is a 400 for a request that fails validation.
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+// Internal is a 500 whose message says what failed and whose cause says
+// why, for the logs.
+func Internal(message string, cause error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Cause: cause}
+}
+
+// CodeFor is the code of a bare HTTP status, for errors that did not come
+// from this package such as the router's 404.
+func CodeFor(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return CodeUnauthorized
+	case status == http.StatusNotFound, status == http.StatusMethodNotAllowed:
+		return CodeNotFound
+	case status == http.StatusConflict:
+		return CodeConflict
+	case status == http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case status >= http.StatusInternalServerError:
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// Body is the JSON envelope of e.
+type Body struct {
+	Error BodyError `json:"error"`
+}
+
+type BodyError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// Body returns the envelope of e for the request with requestID.
+func (e *Error) Body(requestID string) Body {
+	return Body{Error: BodyError{Code: e.Code, Message: e.Message, Details: e.Details, RequestID: requestID}}
+}
+
+// Write answers a net/http request with e, for handlers outside Fiber.
+func Write(w http.ResponseWriter, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	_ = json.NewEncoder(w).Encode(e.Body(""))
+}
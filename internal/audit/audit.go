@@ -0,0 +1,59 @@
+// Package audit records changes operators make through admin tools.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/jackc/pgconn"
+)
+
+// MaxDetails is how much of a request body an entry keeps.
+const MaxDetails = 4096
+
+// MaxPath is the length of audit_log.path.
+const MaxPath = 1000
+
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Entry is one audited request.
+type Entry struct {
+	Actor     string
+	Role      string
+	Method    string
+	Path      string
+	Status    int
+	Details   string
+	RequestID string
+	ClientIP  string
+}
+
+// Record writes e to audit_log.
+func Record(ctx context.Context, db Execer, e Entry) error {
+	details := truncate(e.Details, MaxDetails)
+	path := truncate(e.Path, MaxPath)
+	_, err := db.Exec(
+		ctx,
+		`INSERT INTO audit_log (actor, role, method, path, status, details, request_id, client_ip)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''))`,
+		e.Actor, e.Role, e.Method, path, e.Status, details, e.RequestID, e.ClientIP,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,26 @@
+package audit
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"shorter than the limit", "abc", 4, "abc"},
+		{"exactly the limit", "abcd", 4, "abcd"},
+		{"ascii is cut at the limit", "abcdef", 4, "abcd"},
+		{"a rune across the limit is dropped", "abcสวัสดี", 4, "abc"},
+		{"a rune ending at the limit is kept", "abส", 5, "abส"},
+		{"a cut inside the first rune leaves nothing", "ส", 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncate(tt.s, tt.n); got != tt.want {
+				t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Package correlation carries the request ID that ties an HTTP request to
+// the tasks it enqueues and the ledger rows those write.
+package correlation
+
+import "context"
+
+// Header is the HTTP header the request ID is accepted from and echoed in.
+const Header = "X-Request-ID"
+
+// MaxIDLength is the longest request ID accepted from a client.
+const MaxIDLength = 64
+
+type key struct{}
+
+// Key is the context key of the request ID. Fiber keeps c.Locals on the
+// fasthttp request context, so a request ID stored with
+// c.Locals(Key, id) is also returned by ID(c.Context()).
+var Key any = key{}
+
+// WithID returns a copy of ctx carrying request ID id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, Key, id)
+}
+
+// ID returns the request ID carried by ctx, or "" when there is none.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(Key).(string)
+	return id
+}
+
+// ValidID reports whether id is acceptable as a request ID from a client:
+// non-empty, at most MaxIDLength characters of letters, digits, '-', '_',
+// '.' and ':'.
+func ValidID(id string) bool {
+	if id == "" || len(id) > MaxIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -4,6 +4,11 @@ import (
 	"context"
 	"fmt"
 
+	"atlasq/internal/tracing"
+
+	"github.com/jackc/pgtype"
+	shopspring "github.com/jackc/pgtype/ext/shopspring-numeric"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
 )
@@ -13,7 +18,24 @@ type PostgreSQL struct {
 
 func (pg *PostgreSQL) Connect() (*pgxpool.Pool, error) {
 
-	pool, err := pgxpool.Connect(context.Background(), pg.ConnectionURI())
+	config, err := pgxpool.ParseConfig(pg.ConnectionURI())
+	if err != nil {
+		return nil, err
+	}
+	// scan/encode NUMERIC as decimal.Decimal instead of going through float64
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		conn.ConnInfo().RegisterDataType(pgtype.DataType{
+			Value: &shopspring.Numeric{},
+			Name:  "numeric",
+			OID:   pgtype.NumericOID,
+		})
+		return nil
+	}
+	// queries run inside a traced request or task are recorded as spans
+	config.ConnConfig.Logger = tracing.QueryLogger{}
+	config.ConnConfig.LogLevel = pgx.LogLevelInfo
+
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
 	if err != nil {
 		return nil, err
 	}
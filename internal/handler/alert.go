@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"errors"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Alert serves stock minimums and the low-stock alerts they raise.
+type Alert struct {
+	Pool *pgxpool.Pool
+}
+
+type StockMinimumRequest struct {
+	ProductID   int64           `json:"product_id" validate:"required"`
+	WarehouseID int64           `json:"warehouse_id" validate:"required"`
+	Minimum     decimal.Decimal `json:"minimum" validate:"gte=0,scale=4"`
+}
+
+func (h *Alert) Register(app fiber.Router) {
+	app.Put("/api/v1/stock-minimums", h.SetMinimum)
+	app.Get("/api/v1/alerts", h.List)
+}
+
+// SetMinimum sets the reorder point of a product in a warehouse; 0 turns
+// alerts off. The stock row must exist, receiving is what creates it.
+func (h *Alert) SetMinimum(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req StockMinimumRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	var stockID int64
+	var quantity decimal.Decimal
+	err = tx.QueryRow(
+		c.Context(),
+		`UPDATE stock SET minimum = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE tenant_id = $2 AND product_id = $3 AND warehouse_id = $4
+		RETURNING id, quantity`,
+		req.Minimum, tenantID, req.ProductID, req.WarehouseID,
+	).Scan(&stockID, &quantity)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierr.NotFound("stock not found")
+	}
+	if err != nil {
+		return apierr.Internal("failed to update minimum", err)
+	}
+	// a stock row already at the new minimum alerts right away
+	if err := inventory.CheckLowStock(c.Context(), tx, stockID); err != nil {
+		return apierr.Internal("failed to check low stock", err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.JSON(fiber.Map{
+		"stock_id": stockID,
+		"minimum":  req.Minimum,
+		"quantity": quantity,
+	})
+}
+
+// List returns the tenant's low-stock alerts, newest first. ?status=open
+// only returns alerts not restocked yet, ?status=resolved the others.
+func (h *Alert) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	status := c.Query("status")
+	if status != "" && status != "open" && status != "resolved" {
+		return apierr.BadRequest("status must be open or resolved")
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT id, tenant_id, stock_id, product_id, warehouse_id, minimum, quantity, create_date, delivered_date, resolved_date
+		FROM stock_alert
+		WHERE tenant_id = $1
+			AND ($2 = 0 OR product_id = $2)
+			AND ($3 = 0 OR warehouse_id = $3)
+			AND ($4 = '' OR ($4 = 'open') = (resolved_date IS NULL))
+		ORDER BY id DESC
+		LIMIT 1000`,
+		tenantID, c.QueryInt("product_id"), c.QueryInt("warehouse_id"), status,
+	)
+	if err != nil {
+		return apierr.Internal("failed to list alerts", err)
+	}
+	defer rows.Close()
+
+	alerts := []inventory.StockAlert{}
+	for rows.Next() {
+		var a inventory.StockAlert
+		if err := rows.Scan(&a.ID, &a.TenantID, &a.StockID, &a.ProductID, &a.WarehouseID, &a.Minimum, &a.Quantity, &a.CreateDate, &a.DeliveredDate, &a.ResolvedDate); err != nil {
+			return apierr.Internal("failed to list alerts", err)
+		}
+		alerts = append(alerts, a)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list alerts", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"alerts": alerts})
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"errors"
+	"time"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Allocation serves the tenant's multi-warehouse allocation policy and the
+// allocation results recorded for orders placed without a warehouse_id.
+type Allocation struct {
+	Pool *pgxpool.Pool
+}
+
+type AllocationPolicyRequest struct {
+	Policy            string  `json:"policy" validate:"oneof=PRIORITY MOST_STOCK FEWEST_SPLITS"`
+	WarehousePriority []int64 `json:"warehouse_priority" validate:"unique"`
+}
+
+func (h *Allocation) Register(app fiber.Router) {
+	app.Get("/api/v1/allocation-policy", h.GetPolicy)
+	app.Put("/api/v1/allocation-policy", h.SetPolicy)
+	app.Get("/api/v1/allocations/:id", h.Get)
+}
+
+func (h *Allocation) GetPolicy(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	policy := inventory.DefaultAllocationPolicy
+	priority := []int64{}
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`SELECT policy, warehouse_priority FROM allocation_policy WHERE tenant_id = $1`,
+		tenantID,
+	).Scan(&policy, &priority)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return apierr.Internal("failed to load allocation policy", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"policy":             policy,
+		"warehouse_priority": priority,
+	})
+}
+
+func (h *Allocation) SetPolicy(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req AllocationPolicyRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	if req.Policy == inventory.AllocatePriority && len(req.WarehousePriority) == 0 {
+		return apierr.BadRequest("warehouse_priority is required for PRIORITY")
+	}
+	if req.WarehousePriority == nil {
+		req.WarehousePriority = []int64{}
+	}
+
+	_, err = h.Pool.Exec(
+		c.Context(),
+		`INSERT INTO allocation_policy (tenant_id, policy, warehouse_priority) VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			policy = EXCLUDED.policy,
+			warehouse_priority = EXCLUDED.warehouse_priority,
+			update_date = CURRENT_TIMESTAMP,
+			row_update_date = CURRENT_TIMESTAMP`,
+		tenantID, req.Policy, req.WarehousePriority,
+	)
+	if err != nil {
+		return apierr.Internal("failed to update allocation policy", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Allocation policy updated"})
+}
+
+func (h *Allocation) Get(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid allocation ID")
+	}
+
+	alloc := inventory.Allocation{ID: int64(id), Fulfillments: []inventory.Fulfillment{}}
+	var createDate time.Time
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`SELECT policy, create_date FROM order_allocation WHERE id = $1 AND tenant_id = $2`,
+		id, tenantID,
+	).Scan(&alloc.Policy, &createDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierr.NotFound("allocation not found")
+	}
+	if err != nil {
+		return apierr.Internal("failed to load allocation", err)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT warehouse_id, product_id, quantity, COALESCE(bundle_product_id, 0) FROM order_fulfillment WHERE allocation_id = $1 ORDER BY id`,
+		id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to load allocation", err)
+	}
+	defer rows.Close()
+
+	index := map[int64]int{}
+	for rows.Next() {
+		var warehouseID int64
+		var item inventory.Line
+		if err := rows.Scan(&warehouseID, &item.ProductID, &item.Quantity, &item.BundleProductID); err != nil {
+			return apierr.Internal("failed to load allocation", err)
+		}
+		i, ok := index[warehouseID]
+		if !ok {
+			i = len(alloc.Fulfillments)
+			index[warehouseID] = i
+			alloc.Fulfillments = append(alloc.Fulfillments, inventory.Fulfillment{WarehouseID: warehouseID})
+		}
+		alloc.Fulfillments[i].Items = append(alloc.Fulfillments[i].Items, item)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load allocation", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{
+		"allocation":  alloc,
+		"create_date": createDate,
+	})
+}
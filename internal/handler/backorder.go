@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"time"
+
+	"atlasq/internal/inventory"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Backorder lists order shortfalls waiting for a receipt.
+type Backorder struct {
+	Pool *pgxpool.Pool
+}
+
+type BackorderResponse struct {
+	ID           int64           `json:"id"`
+	WarehouseID  *int64          `json:"warehouse_id"`
+	ProductID    int64           `json:"product_id"`
+	AllocationID *int64          `json:"allocation_id"`
+	Quantity     decimal.Decimal `json:"quantity"`
+	Filled       decimal.Decimal `json:"filled"`
+	Status       string          `json:"status"`
+	CreateDate   time.Time       `json:"create_date"`
+}
+
+func (h *Backorder) Register(app fiber.Router) {
+	app.Get("/api/v1/backorders", h.List)
+}
+
+func (h *Backorder) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	status := c.Query("status", inventory.BackorderOpen)
+	if status != inventory.BackorderOpen && status != inventory.BackorderFilled {
+		return apierr.BadRequest("status must be OPEN or FILLED")
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT id, warehouse_id, product_id, allocation_id, quantity, filled, status, create_date
+		FROM backorder
+		WHERE tenant_id = $1 AND status = $2 AND ($3 = 0 OR product_id = $3)
+		ORDER BY id`,
+		tenantID, status, c.QueryInt("product_id"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to list backorders", err)
+	}
+	defer rows.Close()
+
+	backorders := []BackorderResponse{}
+	for rows.Next() {
+		var b BackorderResponse
+		if err := rows.Scan(&b.ID, &b.WarehouseID, &b.ProductID, &b.AllocationID, &b.Quantity, &b.Filled, &b.Status, &b.CreateDate); err != nil {
+			return apierr.Internal("failed to list backorders", err)
+		}
+		backorders = append(backorders, b)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list backorders", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"backorders": backorders})
+}
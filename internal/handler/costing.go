@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Costing serves the tenant's inventory costing method.
+type Costing struct {
+	Pool *pgxpool.Pool
+}
+
+type CostingMethodRequest struct {
+	Method string `json:"method" validate:"oneof=FIFO AVERAGE"`
+}
+
+func (h *Costing) Register(app fiber.Router) {
+	app.Get("/api/v1/costing-method", h.GetMethod)
+	app.Put("/api/v1/costing-method", h.SetMethod)
+}
+
+func (h *Costing) GetMethod(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	method, err := inventory.LoadCostingMethod(c.Context(), h.Pool, tenantID)
+	if err != nil {
+		return apierr.Internal("failed to load costing method", err)
+	}
+	return c.JSON(fiber.Map{"method": method})
+}
+
+// SetMethod switches the costing method from now on; past ledger rows keep
+// their cost. Switching to FIFO restarts the layers at the average cost.
+func (h *Costing) SetMethod(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req CostingMethodRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	current, err := inventory.LoadCostingMethod(c.Context(), tx, tenantID)
+	if err != nil {
+		return apierr.Internal("failed to load costing method", err)
+	}
+	if current == req.Method {
+		return c.JSON(fiber.Map{"message": "Costing method unchanged"})
+	}
+
+	_, err = tx.Exec(
+		c.Context(),
+		`INSERT INTO costing_method (tenant_id, method) VALUES ($1, $2)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			method = EXCLUDED.method,
+			update_date = CURRENT_TIMESTAMP,
+			row_update_date = CURRENT_TIMESTAMP`,
+		tenantID, req.Method,
+	)
+	if err != nil {
+		return apierr.Internal("failed to update costing method", err)
+	}
+	if req.Method == inventory.CostFIFO {
+		if err := inventory.ResetLayers(c.Context(), tx, tenantID); err != nil {
+			return apierr.Internal("failed to update costing method", err)
+		}
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.JSON(fiber.Map{"message": "Costing method updated"})
+}
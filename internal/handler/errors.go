@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"atlasq/internal/apierr"
+	"atlasq/internal/inventory"
+	"atlasq/internal/numeric"
+)
+
+// Error codes of the inventory rules, in addition to the generic ones in
+// apierr. Clients may switch on them, so they never change.
+const (
+	CodeProductNotFound     = "product_not_found"
+	CodeVariantRequired     = "variant_required"
+	CodeUnknownUnit         = "unknown_unit"
+	CodeInvalidSerials      = "invalid_serials"
+	CodeInvalidQuantity     = "invalid_quantity"
+	CodeInsufficientStock   = "insufficient_stock"
+	CodeStockFrozen         = "stock_frozen"
+	CodeBundleStock         = "bundle_stock"
+	CodeInvalidLot          = "invalid_lot"
+	CodeInvalidLocation     = "invalid_location"
+	CodeOverReceipt         = "over_receipt"
+	CodeInvalidLine         = "invalid_line"
+	CodePurchaseOrderClosed = "purchase_order_closed"
+	CodeInvalidCountLine    = "invalid_count_line"
+	CodeCountNotOpen        = "count_not_open"
+	CodeApprovalRequired    = "approval_required"
+)
+
+// InventoryError maps the typed errors of the inventory package to API
+// errors. Anything else is a 500 with fallback as its message.
+func InventoryError(err error, fallback string) error {
+	var productErr *inventory.ProductNotFoundError
+	var variantErr *inventory.VariantRequiredError
+	var unitErr *inventory.UnknownUnitError
+	var serialErr *inventory.SerialError
+	var stockErr *inventory.InsufficientStockError
+	var frozenErr *inventory.StockFrozenError
+	var overErr *inventory.OverReceiptError
+	var lineErr *inventory.PurchaseLineError
+	var countErr *inventory.CountLineError
+	switch {
+	case errors.As(err, &productErr):
+		return apierr.New(http.StatusBadRequest, CodeProductNotFound, "product not found").
+			With("product_id", productErr.ProductID)
+	case errors.As(err, &variantErr):
+		return apierr.New(http.StatusBadRequest, CodeVariantRequired, "product has variants, use a variant instead").
+			With("product_id", variantErr.ProductID)
+	case errors.As(err, &unitErr):
+		return apierr.New(http.StatusBadRequest, CodeUnknownUnit, "unknown unit").
+			With("product_id", unitErr.ProductID).
+			With("unit", unitErr.Unit)
+	case errors.As(err, &serialErr):
+		e := apierr.New(http.StatusBadRequest, CodeInvalidSerials, err.Error()).
+			With("product_id", serialErr.ProductID)
+		if serialErr.Serial != "" {
+			e = e.With("serial", serialErr.Serial)
+		}
+		return e
+	case errors.As(err, &stockErr):
+		return apierr.New(http.StatusBadRequest, CodeInsufficientStock, "not enough stock").
+			With("product_id", stockErr.ProductID).
+			With("stock", stockErr.Stock).
+			With("required", stockErr.Required)
+	case errors.As(err, &frozenErr):
+		return apierr.New(http.StatusConflict, CodeStockFrozen, "stock is frozen by a stock count").
+			With("product_id", frozenErr.ProductID).
+			With("count_id", frozenErr.CountID)
+	case errors.As(err, &overErr):
+		return apierr.New(http.StatusBadRequest, CodeOverReceipt, "receipt exceeds the ordered quantity").
+			With("line_id", overErr.LineID).
+			With("product_id", overErr.ProductID).
+			With("allowed", overErr.Allowed).
+			With("received", overErr.Received)
+	case errors.As(err, &lineErr):
+		return apierr.New(http.StatusBadRequest, CodeInvalidLine, err.Error()).
+			With("line_id", lineErr.LineID)
+	case errors.As(err, &countErr):
+		e := apierr.New(http.StatusBadRequest, CodeInvalidCountLine, err.Error())
+		if countErr.ProductID != 0 {
+			e = e.With("product_id", countErr.ProductID)
+		}
+		return e
+	case errors.Is(err, inventory.ErrBundleStock):
+		return apierr.New(http.StatusBadRequest, CodeBundleStock, err.Error())
+	case errors.Is(err, inventory.ErrLotNotFound),
+		errors.Is(err, inventory.ErrNotEnoughInLot),
+		errors.Is(err, inventory.ErrLotExpiryMismatch),
+		errors.Is(err, inventory.ErrLotsExpired):
+		return apierr.New(http.StatusBadRequest, CodeInvalidLot, err.Error())
+	case errors.Is(err, inventory.ErrNotEnoughUnlocated),
+		errors.Is(err, inventory.ErrNotEnoughInBin):
+		return apierr.New(http.StatusBadRequest, CodeInvalidLocation, err.Error())
+	case errors.Is(err, numeric.ErrTooPrecise):
+		return apierr.New(http.StatusBadRequest, CodeInvalidQuantity, err.Error())
+	case errors.Is(err, inventory.ErrPurchaseOrderNotFound),
+		errors.Is(err, inventory.ErrCountNotFound):
+		return apierr.NotFound(err.Error())
+	case errors.Is(err, inventory.ErrPurchaseOrderClosed):
+		return apierr.New(http.StatusConflict, CodePurchaseOrderClosed, err.Error())
+	case errors.Is(err, inventory.ErrCountNotOpen):
+		return apierr.New(http.StatusConflict, CodeCountNotOpen, err.Error())
+	}
+	return apierr.Internal(fallback, err)
+}
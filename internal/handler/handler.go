@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"errors"
+
+	"atlasq/internal/apierr"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgconn"
+)
+
+// queryTenant reads the required ?tenant= query string. Its error is an
+// *apierr.Error ready to be returned.
+func queryTenant(c *fiber.Ctx) (int64, error) {
+	tenantIDStr := c.Query("tenant")
+	if tenantIDStr == "" {
+		return 0, apierr.BadRequest("tenant query string is required")
+	}
+	tenantID, err := strconv.ParseInt(tenantIDStr, 10, 64)
+	if err != nil {
+		return 0, apierr.BadRequest("invalid tenant ID")
+	}
+	return tenantID, nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique_violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
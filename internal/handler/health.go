@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"os"
+
+	"atlasq/internal/health"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Health serves the liveness and readiness probes.
+type Health struct {
+	Checks []health.Check
+	// Draining reports a shutdown or handoff in progress, see
+	// server.Drainer. Optional.
+	Draining func() bool
+}
+
+func (h *Health) Register(app fiber.Router) {
+	app.Get("/healthz", h.Live)
+	app.Get("/readyz", h.Ready)
+}
+
+// Live answers as long as the process can serve requests. The pid tells a
+// deploy which of the processes sharing the port answered.
+func (h *Health) Live(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok", "pid": os.Getpid()})
+}
+
+// Ready answers 503 until Postgres, Redis and the schema version check out,
+// and again once the process starts draining so no new traffic is sent its
+// way.
+func (h *Health) Ready(c *fiber.Ctx) error {
+	if h.Draining != nil && h.Draining() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(health.Report{Status: "draining", Checks: map[string]string{}})
+	}
+	report, ready := health.Ready(c.Context(), h.Checks)
+	if !ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+	}
+	return c.JSON(report)
+}
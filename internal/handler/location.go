@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"context"
+	"errors"
+	"time"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Location serves the zone → aisle → bin hierarchy inside a warehouse and
+// the putaway/move flows that place stock into bins.
+type Location struct {
+	Pool *pgxpool.Pool
+}
+
+type LocationRequest struct {
+	WarehouseID int64  `json:"warehouse_id" validate:"required"`
+	ParentID    *int64 `json:"parent_id"`
+	Type        string `json:"type" validate:"oneof=ZONE AISLE BIN"`
+	Code        string `json:"code" validate:"required,max=50"`
+}
+
+type LocationResponse struct {
+	ID          int64           `json:"id"`
+	WarehouseID int64           `json:"warehouse_id"`
+	ParentID    *int64          `json:"parent_id"`
+	Type        string          `json:"type"`
+	Code        string          `json:"code"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	CreateDate  time.Time       `json:"create_date"`
+}
+
+type PutawayRequest struct {
+	ProductID   int64           `json:"product_id" validate:"required"`
+	WarehouseID int64           `json:"warehouse_id" validate:"required"`
+	LocationID  int64           `json:"location_id" validate:"required"`
+	Quantity    decimal.Decimal `json:"quantity" validate:"gt=0,scale=4"`
+}
+
+type MoveRequest struct {
+	ProductID      int64           `json:"product_id" validate:"required"`
+	WarehouseID    int64           `json:"warehouse_id" validate:"required"`
+	FromLocationID int64           `json:"from_location_id" validate:"required"`
+	ToLocationID   int64           `json:"to_location_id" validate:"required,nefield=FromLocationID"`
+	Quantity       decimal.Decimal `json:"quantity" validate:"gt=0,scale=4"`
+}
+
+type PickStrategyRequest struct {
+	ProductID       int64  `json:"product_id" validate:"required"`
+	WarehouseID     int64  `json:"warehouse_id" validate:"required"`
+	Strategy        string `json:"strategy" validate:"oneof=LARGEST_FIRST FIXED_BIN"`
+	FixedLocationID *int64 `json:"fixed_location_id" validate:"required_if=Strategy FIXED_BIN"`
+}
+
+var (
+	errStockNotFound = errors.New("stock not found")
+	errNotABin       = errors.New("location must be a BIN in the same warehouse")
+)
+
+func (h *Location) Register(app fiber.Router) {
+	app.Post("/api/v1/locations", h.Create)
+	app.Get("/api/v1/locations", h.List)
+	app.Post("/api/v1/locations/putaway", h.Putaway)
+	app.Post("/api/v1/locations/move", h.Move)
+	app.Put("/api/v1/stocks/pick-strategy", h.SetPickStrategy)
+}
+
+func (h *Location) Create(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req LocationRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	// A child must sit one level below its parent: ZONE → AISLE → BIN.
+	if req.ParentID != nil {
+		var parentType string
+		err := h.Pool.QueryRow(
+			c.Context(),
+			`SELECT type FROM location WHERE id = $1 AND tenant_id = $2 AND warehouse_id = $3`,
+			*req.ParentID, tenantID, req.WarehouseID,
+		).Scan(&parentType)
+		if err != nil {
+			return apierr.BadRequest("parent location not found")
+		}
+		if !(parentType == inventory.LocationZone && req.Type == inventory.LocationAisle) &&
+			!(parentType == inventory.LocationAisle && req.Type == inventory.LocationBin) {
+			return apierr.BadRequest("a ZONE may contain AISLEs and an AISLE may contain BINs")
+		}
+	}
+
+	var id int64
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`INSERT INTO location (tenant_id, warehouse_id, parent_id, type, code) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		tenantID, req.WarehouseID, req.ParentID, req.Type, req.Code,
+	).Scan(&id)
+	if err != nil {
+		return apierr.Internal("failed to create location", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Location created",
+		"id":      id,
+	})
+}
+
+func (h *Location) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	warehouseID := c.QueryInt("warehouse_id")
+	if warehouseID == 0 {
+		return apierr.BadRequest("warehouse_id query string is required")
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT l.id, l.warehouse_id, l.parent_id, l.type, l.code, l.create_date,
+			COALESCE((SELECT SUM(sl.quantity) FROM stock_location sl WHERE sl.location_id = l.id), 0)
+		FROM location l
+		WHERE l.tenant_id = $1 AND l.warehouse_id = $2 AND l.status = true
+		ORDER BY l.code`,
+		tenantID, warehouseID,
+	)
+	if err != nil {
+		return apierr.Internal("failed to list locations", err)
+	}
+	defer rows.Close()
+
+	locations := []LocationResponse{}
+	for rows.Next() {
+		var l LocationResponse
+		if err := rows.Scan(&l.ID, &l.WarehouseID, &l.ParentID, &l.Type, &l.Code, &l.CreateDate, &l.Quantity); err != nil {
+			return apierr.Internal("failed to list locations", err)
+		}
+		locations = append(locations, l)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list locations", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"locations": locations})
+}
+
+func (h *Location) Putaway(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req PutawayRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	stockID, err := lockStock(c.Context(), tx, tenantID, req.WarehouseID, req.ProductID)
+	if err == nil {
+		err = checkBin(c.Context(), tx, tenantID, req.WarehouseID, req.LocationID)
+	}
+	if err == nil {
+		err = inventory.Putaway(c.Context(), tx, tenantID, stockID, req.LocationID, req.Quantity)
+	}
+	if err != nil {
+		return locationError(c, err, "failed to put away stock")
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Stock put away"})
+}
+
+func (h *Location) Move(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req MoveRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	stockID, err := lockStock(c.Context(), tx, tenantID, req.WarehouseID, req.ProductID)
+	if err == nil {
+		err = checkBin(c.Context(), tx, tenantID, req.WarehouseID, req.ToLocationID)
+	}
+	if err == nil {
+		err = inventory.Move(c.Context(), tx, tenantID, stockID, req.FromLocationID, req.ToLocationID, req.Quantity)
+	}
+	if err != nil {
+		return locationError(c, err, "failed to move stock")
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Stock moved"})
+}
+
+func (h *Location) SetPickStrategy(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req PickStrategyRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	stockID, err := lockStock(c.Context(), tx, tenantID, req.WarehouseID, req.ProductID)
+	if err == nil && req.FixedLocationID != nil {
+		err = checkBin(c.Context(), tx, tenantID, req.WarehouseID, *req.FixedLocationID)
+	}
+	if err != nil {
+		return locationError(c, err, "failed to update pick strategy")
+	}
+
+	_, err = tx.Exec(
+		c.Context(),
+		`UPDATE stock SET pick_strategy = $1, fixed_location_id = $2, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $3`,
+		req.Strategy, req.FixedLocationID, stockID,
+	)
+	if err != nil {
+		return apierr.Internal("failed to update pick strategy", err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Pick strategy updated"})
+}
+
+// lockStock returns the stock row for product/warehouse and locks it for the
+// rest of the transaction.
+func lockStock(ctx context.Context, tx pgx.Tx, tenantID, warehouseID, productID int64) (int64, error) {
+	var stockID int64
+	err := tx.QueryRow(
+		ctx,
+		`SELECT id FROM stock WHERE product_id = $1 AND warehouse_id = $2 AND tenant_id = $3 FOR UPDATE`,
+		productID, warehouseID, tenantID,
+	).Scan(&stockID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errStockNotFound
+	}
+	return stockID, err
+}
+
+// checkBin makes sure locationID is an active BIN of the tenant's warehouse.
+func checkBin(ctx context.Context, tx pgx.Tx, tenantID, warehouseID, locationID int64) error {
+	var ok bool
+	err := tx.QueryRow(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM location WHERE id = $1 AND tenant_id = $2 AND warehouse_id = $3 AND type = $4 AND status = true)`,
+		locationID, tenantID, warehouseID, inventory.LocationBin,
+	).Scan(&ok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotABin
+	}
+	return nil
+}
+
+func locationError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, errStockNotFound):
+		return apierr.NotFound(err.Error())
+	case errors.Is(err, errNotABin):
+		return apierr.New(fiber.StatusBadRequest, CodeInvalidLocation, err.Error())
+	}
+	return InventoryError(err, fallback)
+}
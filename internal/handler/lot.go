@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Lot lists the lots held under stock rows, in the order orders pick them.
+type Lot struct {
+	Pool *pgxpool.Pool
+}
+
+type LotResponse struct {
+	ID             int64           `json:"id"`
+	StockID        int64           `json:"stock_id"`
+	WarehouseID    int64           `json:"warehouse_id"`
+	ProductID      int64           `json:"product_id"`
+	LotNumber      string          `json:"lot_number"`
+	ExpiryDate     *time.Time      `json:"expiry_date"`
+	Quantity       decimal.Decimal `json:"quantity"`
+	NearExpiry     bool            `json:"near_expiry"`
+	NearExpiryDate *time.Time      `json:"near_expiry_date"`
+}
+
+func (h *Lot) Register(app fiber.Router) {
+	app.Get("/api/v1/lots", h.List)
+}
+
+// List returns lots with stock left, first-expiring first. ?expiring=true
+// only returns lots flagged by the daily expiry job.
+func (h *Lot) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT l.id, l.stock_id, s.warehouse_id, s.product_id, l.lot_number, l.expiry_date, l.quantity, l.near_expiry_date
+		FROM stock_lot l
+		JOIN stock s ON s.id = l.stock_id
+		WHERE l.tenant_id = $1 AND l.quantity > 0
+			AND ($2 = 0 OR s.product_id = $2)
+			AND ($3 = 0 OR s.warehouse_id = $3)
+			AND (NOT $4 OR l.near_expiry_date IS NOT NULL)
+		ORDER BY l.expiry_date NULLS LAST, l.id`,
+		tenantID, c.QueryInt("product_id"), c.QueryInt("warehouse_id"), c.QueryBool("expiring"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to list lots", err)
+	}
+	defer rows.Close()
+
+	lots := []LotResponse{}
+	for rows.Next() {
+		var l LotResponse
+		if err := rows.Scan(&l.ID, &l.StockID, &l.WarehouseID, &l.ProductID, &l.LotNumber, &l.ExpiryDate, &l.Quantity, &l.NearExpiryDate); err != nil {
+			return apierr.Internal("failed to list lots", err)
+		}
+		l.NearExpiry = l.NearExpiryDate != nil
+		lots = append(lots, l)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list lots", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"lots": lots})
+}
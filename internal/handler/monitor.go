@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"atlasq/internal/admin"
+	"atlasq/internal/apierr"
+	"atlasq/internal/audit"
+	"atlasq/internal/correlation"
+	"atlasq/internal/middleware"
+
+	"github.com/gofiber/adaptor/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+	"github.com/hibiken/asynqmon"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// MonitorPath is where the asynqmon dashboard is served.
+const MonitorPath = "/monitor"
+
+// Monitor serves the asynqmon dashboard behind admin basic auth. Viewers
+// get a read-only dashboard, and so does everyone when ReadOnly is set.
+// Every request that could change the queues is written to audit_log,
+// whether asynqmon allowed it or not.
+type Monitor struct {
+	Pool     *pgxpool.Pool
+	Redis    asynq.RedisClientOpt
+	ReadOnly bool
+}
+
+func (h *Monitor) Register(app fiber.Router) {
+	full := adaptor.HTTPHandler(asynqmon.New(asynqmon.Options{
+		RootPath:     MonitorPath,
+		RedisConnOpt: h.Redis,
+		ReadOnly:     h.ReadOnly,
+	}))
+	readOnly := adaptor.HTTPHandler(asynqmon.New(asynqmon.Options{
+		RootPath:     MonitorPath,
+		RedisConnOpt: h.Redis,
+		ReadOnly:     true,
+	}))
+
+	app.Use(MonitorPath, func(c *fiber.Ctx) error {
+		// without credentials the dashboard is off rather than open
+		if !admin.BasicEnabled() {
+			return apierr.NotFound("not found")
+		}
+		user, role, ok := admin.CheckBasic(c.Get(fiber.HeaderAuthorization))
+		if !ok {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="atlasq monitor"`)
+			return apierr.Unauthorized("unauthorized")
+		}
+
+		dashboard := full
+		if role != admin.RoleAdmin {
+			dashboard = readOnly
+		}
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return dashboard(c)
+		}
+
+		err := dashboard(c)
+		entry := audit.Entry{
+			Actor:     user,
+			Role:      role,
+			Method:    c.Method(),
+			Path:      c.OriginalURL(),
+			Status:    c.Response().StatusCode(),
+			Details:   string(c.Body()),
+			RequestID: correlation.ID(c.Context()),
+			ClientIP:  c.IP(),
+		}
+		if auditErr := audit.Record(c.Context(), h.Pool, entry); auditErr != nil {
+			middleware.Logger(c).Error("failed to audit monitor action",
+				zap.String("actor", user), zap.String("method", entry.Method), zap.String("path", entry.Path), zap.Error(auditErr))
+		}
+		return err
+	})
+}
@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Product serves the product catalog.
+type Product struct {
+	Pool *pgxpool.Pool
+}
+
+// Price is a pointer so that a price of 0 counts as given.
+type ProductRequest struct {
+	Name        string           `json:"name" validate:"required,max=255"`
+	Description string           `json:"description"`
+	Price       *decimal.Decimal `json:"price" validate:"required,gte=0,scale=4"`
+	SKU         string           `json:"sku"`
+	Serialized  bool             `json:"serialized"`
+}
+
+// ProductPatchRequest only updates the fields that are present.
+// PriceOverride only applies to variants.
+type ProductPatchRequest struct {
+	Name          *string          `json:"name" validate:"omitempty,min=1,max=255"`
+	Description   *string          `json:"description"`
+	Price         *decimal.Decimal `json:"price" validate:"omitempty,gte=0,scale=4"`
+	PriceOverride *decimal.Decimal `json:"price_override" validate:"omitempty,gt=0,scale=4"`
+	SKU           *string          `json:"sku"`
+	Serialized    *bool            `json:"serialized"`
+}
+
+// Price is the effective price: a variant's price_override, or else the
+// parent's price.
+type ProductResponse struct {
+	ID            int64            `json:"id"`
+	ParentID      *int64           `json:"parent_id"`
+	Type          string           `json:"type"`
+	Name          string           `json:"name"`
+	Description   string           `json:"description"`
+	Price         decimal.Decimal  `json:"price"`
+	PriceOverride *decimal.Decimal `json:"price_override"`
+	SKU           string           `json:"sku"`
+	BaseUnit      string           `json:"base_unit"`
+	Serialized    bool             `json:"serialized"`
+	Archived      bool             `json:"archived"`
+	ArchivedDate  *time.Time       `json:"archived_date"`
+	CreateDate    time.Time        `json:"create_date"`
+	UpdateDate    time.Time        `json:"update_date"`
+}
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// productSorts maps the ?sort= values to ORDER BY clauses. A leading "-"
+// sorts descending.
+var productSorts = map[string]string{
+	"id":          "id",
+	"name":        "name",
+	"sku":         "sku",
+	"price":       "price",
+	"create_date": "create_date",
+}
+
+const productColumns = `id, parent_id, type, name, COALESCE(description, ''),
+	COALESCE(price_override, (SELECT pp.price FROM product pp WHERE pp.id = product.parent_id), price),
+	price_override, COALESCE(sku, ''), base_unit, serialized, archived_date, create_date, update_date`
+
+var errProductNotFound = errors.New("product not found")
+
+// likeEscaper makes a search term match literally inside an ILIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (h *Product) Register(app fiber.Router) {
+	app.Post("/api/v1/products", h.Create)
+	app.Get("/api/v1/products", h.List)
+	app.Get("/api/v1/products/sku/:sku", h.GetBySKU)
+	app.Get("/api/v1/products/:id", h.Get)
+	app.Patch("/api/v1/products/:id", h.Update)
+	app.Post("/api/v1/products/:id/archive", h.Archive)
+	app.Put("/api/v1/products/:id/options", h.SetOptions)
+	app.Get("/api/v1/products/:id/variants", h.ListVariants)
+	app.Post("/api/v1/products/:id/variants", h.GenerateVariants)
+	app.Get("/api/v1/products/:id/stock", h.StockTotals)
+	app.Get("/api/v1/products/:id/components", h.GetComponents)
+	app.Put("/api/v1/products/:id/components", h.SetComponents)
+	app.Get("/api/v1/products/:id/availability", h.Availability)
+	app.Get("/api/v1/products/:id/units", h.GetUnits)
+	app.Put("/api/v1/products/:id/units", h.SetUnits)
+}
+
+func (h *Product) Create(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	// Validate tenant exists
+	var exists bool
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`SELECT EXISTS(SELECT 1 FROM tenant WHERE id = $1)`, tenantID,
+	).Scan(&exists)
+	if err != nil {
+		return apierr.Internal("failed to validate tenant", err)
+	}
+	if !exists {
+		return apierr.BadRequest("tenant not found")
+	}
+
+	var req ProductRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	var id int64
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`INSERT INTO product (tenant_id, name, description, price, sku, serialized) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		tenantID, req.Name, req.Description, *req.Price, req.SKU, req.Serialized,
+	).Scan(&id)
+	if isUniqueViolation(err) {
+		return apierr.Conflict("sku already exists").With("sku", req.SKU)
+	}
+	if err != nil {
+		return apierr.Internal("failed to insert product", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Product created",
+		"id":      id,
+		"name":    req.Name,
+	})
+}
+
+func (h *Product) Get(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	p, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return productError(c, err)
+	}
+	return c.JSON(p)
+}
+
+func (h *Product) GetBySKU(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	p, err := h.find(c.Context(), `tenant_id = $1 AND sku = $2`, tenantID, c.Params("sku"))
+	if err != nil {
+		return productError(c, err)
+	}
+	return c.JSON(p)
+}
+
+// List supports ?q= (name/SKU search), ?page=, ?per_page=, ?sort= and
+// ?archived=true to include archived products.
+func (h *Product) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	page := c.QueryInt("page", 1)
+	perPage := c.QueryInt("per_page", defaultPerPage)
+	if page < 1 || perPage < 1 || perPage > maxPerPage {
+		return apierr.BadRequest(fmt.Sprintf("page must be >= 1 and per_page between 1 and %d", maxPerPage))
+	}
+
+	sort := c.Query("sort", "id")
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		sort, direction = sort[1:], "DESC"
+	}
+	column, ok := productSorts[sort]
+	if !ok {
+		return apierr.BadRequest("unknown sort field")
+	}
+
+	where := `tenant_id = $1 AND ($2 = '' OR name ILIKE '%' || $2 || '%' ESCAPE '\' OR sku ILIKE '%' || $2 || '%' ESCAPE '\')`
+	if !c.QueryBool("archived") {
+		where += ` AND archived_date IS NULL`
+	}
+	q := likeEscaper.Replace(c.Query("q"))
+
+	var total int64
+	err = h.Pool.QueryRow(c.Context(), `SELECT COUNT(*) FROM product WHERE `+where, tenantID, q).Scan(&total)
+	if err != nil {
+		return apierr.Internal("failed to list products", err)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT `+productColumns+` FROM product WHERE `+where+
+			fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT $3 OFFSET $4`, column, direction, direction),
+		tenantID, q, perPage, (page-1)*perPage,
+	)
+	if err != nil {
+		return apierr.Internal("failed to list products", err)
+	}
+	defer rows.Close()
+
+	products := []ProductResponse{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return apierr.Internal("failed to list products", err)
+		}
+		products = append(products, *p)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list products", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{
+		"products": products,
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+	})
+}
+
+func (h *Product) Update(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	var req ProductPatchRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	tag, err := h.Pool.Exec(
+		c.Context(),
+		`UPDATE product SET
+			name = COALESCE($3, name),
+			description = COALESCE($4, description),
+			price = COALESCE($5, price),
+			sku = COALESCE($6, sku),
+			price_override = CASE WHEN parent_id IS NULL THEN NULL ELSE COALESCE($7, price_override) END,
+			serialized = COALESCE($8, serialized),
+			update_date = CURRENT_TIMESTAMP
+		WHERE tenant_id = $1 AND id = $2
+			AND ($8::boolean IS NULL OR $8 = serialized OR NOT EXISTS(SELECT 1 FROM stock WHERE product_id = product.id))`,
+		tenantID, id, req.Name, req.Description, req.Price, req.SKU, req.PriceOverride, req.Serialized,
+	)
+	if isUniqueViolation(err) {
+		return apierr.Conflict("sku already exists").With("sku", req.SKU)
+	}
+	if err != nil {
+		return apierr.Internal("failed to update product", err)
+	}
+	if tag.RowsAffected() == 0 {
+		// either no such product, or stock already exists without serials
+		if _, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id); err != nil {
+			return productError(c, err)
+		}
+		return apierr.Conflict("serialized cannot change while the product has stock")
+	}
+
+	p, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return productError(c, err)
+	}
+	return c.JSON(p)
+}
+
+// Archive hides a product from listings and new orders. Existing stock and
+// ledger rows are kept.
+func (h *Product) Archive(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	tag, err := h.Pool.Exec(
+		c.Context(),
+		`UPDATE product SET archived_date = COALESCE(archived_date, CURRENT_TIMESTAMP), update_date = CURRENT_TIMESTAMP
+		WHERE tenant_id = $1 AND id = $2`,
+		tenantID, id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to archive product", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return productError(c, errProductNotFound)
+	}
+
+	return c.JSON(fiber.Map{"message": "Product archived", "id": id})
+}
+
+func (h *Product) find(ctx context.Context, where string, args ...interface{}) (*ProductResponse, error) {
+	p, err := scanProduct(h.Pool.QueryRow(ctx, `SELECT `+productColumns+` FROM product WHERE `+where, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errProductNotFound
+	}
+	return p, err
+}
+
+func scanProduct(row pgx.Row) (*ProductResponse, error) {
+	var p ProductResponse
+	err := row.Scan(&p.ID, &p.ParentID, &p.Type, &p.Name, &p.Description, &p.Price, &p.PriceOverride, &p.SKU, &p.BaseUnit, &p.Serialized, &p.ArchivedDate, &p.CreateDate, &p.UpdateDate)
+	if err != nil {
+		return nil, err
+	}
+	p.Archived = p.ArchivedDate != nil
+	return &p, nil
+}
+
+func productError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errProductNotFound) {
+		return apierr.NotFound(err.Error())
+	}
+	if errors.Is(err, errIsVariant) {
+		return apierr.BadRequest(err.Error())
+	}
+	return apierr.Internal("failed to load product", err)
+}
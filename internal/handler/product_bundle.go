@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+
+	"atlasq/internal/inventory"
+	tasks "atlasq/internal/tasks"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+)
+
+type BundleRequest struct {
+	Components []inventory.Component `json:"components" validate:"required,min=1,unique=ProductID,dive"`
+}
+
+// SetComponents turns a product into a bundle with the given bill of
+// materials, or replaces the bill of materials of an existing bundle.
+func (h *Product) SetComponents(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	var req BundleRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	items := make([]tasks.OrderItem, 0, len(req.Components))
+	for _, comp := range req.Components {
+		if comp.ProductID == int64(id) {
+			return apierr.BadRequest("a bundle cannot contain itself").With("product_id", comp.ProductID)
+		}
+		items = append(items, tasks.OrderItem{ProductID: comp.ProductID, Quantity: comp.Quantity})
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	variants, err := lockParent(c.Context(), tx, tenantID, int64(id))
+	if err != nil {
+		return productError(c, err)
+	}
+	if variants > 0 {
+		return apierr.BadRequest("a product with variants cannot be a bundle")
+	}
+
+	var hasStock bool
+	err = tx.QueryRow(c.Context(), `SELECT EXISTS(SELECT 1 FROM stock WHERE product_id = $1)`, id).Scan(&hasStock)
+	if err != nil {
+		return apierr.Internal("failed to load stock", err)
+	}
+	if hasStock {
+		return apierr.Conflict("a product with stock rows cannot become a bundle")
+	}
+
+	// components must be stockable products of the tenant, bundles do not nest
+	if err := inventory.CheckProducts(c.Context(), tx, tenantID, items); err != nil {
+		return InventoryError(err, "failed to load components")
+	}
+	// held until commit, so a component cannot turn into a bundle meanwhile
+	if _, err := tx.Exec(c.Context(), `SELECT id FROM product WHERE id = ANY($1) FOR SHARE`, productIDsOf(items)); err != nil {
+		return apierr.Internal("failed to lock components", err)
+	}
+	var isComponent bool
+	err = tx.QueryRow(c.Context(), `SELECT EXISTS(SELECT 1 FROM bundle_component WHERE component_id = $1)`, id).Scan(&isComponent)
+	if err != nil {
+		return apierr.Internal("failed to load components", err)
+	}
+	if isComponent {
+		return apierr.BadRequest("a component of another bundle cannot be a bundle")
+	}
+	nested, err := inventory.LoadComponents(c.Context(), tx, tenantID, productIDsOf(items))
+	if err != nil {
+		return apierr.Internal("failed to load components", err)
+	}
+	if len(nested) > 0 {
+		return apierr.BadRequest("a bundle cannot contain another bundle")
+	}
+
+	_, err = tx.Exec(
+		c.Context(),
+		`UPDATE product SET type = $1, update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+		inventory.ProductBundle, id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to update product", err)
+	}
+	if _, err := tx.Exec(c.Context(), `DELETE FROM bundle_component WHERE bundle_id = $1`, id); err != nil {
+		return apierr.Internal("failed to update components", err)
+	}
+	for _, comp := range req.Components {
+		_, err := tx.Exec(
+			c.Context(),
+			`INSERT INTO bundle_component (bundle_id, component_id, quantity) VALUES ($1, $2, $3)`,
+			id, comp.ProductID, comp.Quantity,
+		)
+		if err != nil {
+			return apierr.Internal("failed to update components", err)
+		}
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Bundle updated", "components": req.Components})
+}
+
+func (h *Product) GetComponents(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+	if _, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id); err != nil {
+		return productError(c, err)
+	}
+
+	components, err := inventory.LoadComponents(c.Context(), h.Pool, tenantID, []int64{int64(id)})
+	if err != nil {
+		return apierr.Internal("failed to load components", err)
+	}
+	bom := components[int64(id)]
+	if bom == nil {
+		bom = []inventory.Component{}
+	}
+
+	return c.JSON(fiber.Map{"product_id": id, "components": bom})
+}
+
+// Availability returns how many units can be shipped, per warehouse and in
+// total. A bundle is available as often as its scarcest component allows.
+func (h *Product) Availability(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+	if _, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id); err != nil {
+		return productError(c, err)
+	}
+
+	components, err := inventory.LoadComponents(c.Context(), h.Pool, tenantID, []int64{int64(id)})
+	if err != nil {
+		return apierr.Internal("failed to load components", err)
+	}
+	productIDs := []int64{int64(id)}
+	for _, comp := range components[int64(id)] {
+		productIDs = append(productIDs, comp.ProductID)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT warehouse_id, product_id, quantity FROM stock
+		WHERE tenant_id = $1 AND product_id = ANY($2) AND status = true AND ($3 = 0 OR warehouse_id = $3)
+		ORDER BY warehouse_id`,
+		tenantID, productIDs, c.QueryInt("warehouse_id"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to load stock", err)
+	}
+	defer rows.Close()
+
+	var order []int64
+	byWarehouse := map[int64]map[int64]decimal.Decimal{}
+	for rows.Next() {
+		var warehouseID, productID int64
+		var quantity decimal.Decimal
+		if err := rows.Scan(&warehouseID, &productID, &quantity); err != nil {
+			return apierr.Internal("failed to load stock", err)
+		}
+		if byWarehouse[warehouseID] == nil {
+			byWarehouse[warehouseID] = map[int64]decimal.Decimal{}
+			order = append(order, warehouseID)
+		}
+		byWarehouse[warehouseID][productID] = byWarehouse[warehouseID][productID].Add(quantity)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load stock", rows.Err())
+	}
+
+	// a bundle's components must come from the same warehouse to count as a set
+	total := decimal.Zero
+	warehouses := []fiber.Map{}
+	for _, warehouseID := range order {
+		units := inventory.Available(int64(id), components, byWarehouse[warehouseID])
+		total = total.Add(units)
+		warehouses = append(warehouses, fiber.Map{"warehouse_id": warehouseID, "available": units})
+	}
+
+	return c.JSON(fiber.Map{
+		"product_id": id,
+		"bundle":     len(components[int64(id)]) > 0,
+		"available":  total,
+		"warehouses": warehouses,
+	})
+}
+
+func productIDsOf(items []tasks.OrderItem) []int64 {
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+	return ids
+}
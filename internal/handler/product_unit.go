@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"errors"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+type ProductUnit struct {
+	Code   string          `json:"code" validate:"required,max=20"`
+	Factor decimal.Decimal `json:"factor" validate:"gt=0,scale=4"`
+}
+
+// ProductUnitsRequest replaces a product's units. Each factor is the number
+// of base units in one unit, e.g. {"code": "CTN", "factor": 24}.
+type ProductUnitsRequest struct {
+	BaseUnit string        `json:"base_unit" validate:"required,max=20"`
+	Units    []ProductUnit `json:"units" validate:"dive"`
+}
+
+// SetUnits sets the base unit of a product and its pack-size conversions.
+// The base unit cannot change once the product holds stock, since stock
+// quantities are kept in it.
+func (h *Product) SetUnits(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	var req ProductUnitsRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	// codes are compared once normalized, so "box" and "BOX " are the same unit
+	req.BaseUnit = inventory.NormalizeUnit(req.BaseUnit)
+	if req.BaseUnit == "" {
+		return apierr.BadRequest("base_unit is required")
+	}
+	seen := map[string]bool{req.BaseUnit: true}
+	for i, u := range req.Units {
+		code := inventory.NormalizeUnit(u.Code)
+		if code == "" || seen[code] {
+			return apierr.BadRequest("unit codes must be distinct and differ from base_unit").With("code", code)
+		}
+		seen[code] = true
+		req.Units[i].Code = code
+	}
+	if req.Units == nil {
+		req.Units = []ProductUnit{}
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	var baseUnit string
+	var hasStock bool
+	err = tx.QueryRow(
+		c.Context(),
+		`SELECT base_unit, EXISTS(SELECT 1 FROM stock WHERE product_id = product.id)
+		FROM product WHERE tenant_id = $1 AND id = $2 FOR UPDATE`,
+		tenantID, id,
+	).Scan(&baseUnit, &hasStock)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return productError(c, errProductNotFound)
+	}
+	if err != nil {
+		return apierr.Internal("failed to load product", err)
+	}
+	if hasStock && baseUnit != req.BaseUnit {
+		return apierr.Conflict("base_unit cannot change while the product has stock").With("base_unit", baseUnit)
+	}
+
+	_, err = tx.Exec(c.Context(), `UPDATE product SET base_unit = $1, update_date = CURRENT_TIMESTAMP WHERE id = $2`, req.BaseUnit, id)
+	if err != nil {
+		return apierr.Internal("failed to update product", err)
+	}
+	if _, err := tx.Exec(c.Context(), `DELETE FROM product_unit WHERE product_id = $1`, id); err != nil {
+		return apierr.Internal("failed to update units", err)
+	}
+	for _, u := range req.Units {
+		_, err := tx.Exec(
+			c.Context(),
+			`INSERT INTO product_unit (product_id, code, factor) VALUES ($1, $2, $3)`,
+			id, u.Code, u.Factor,
+		)
+		if err != nil {
+			return apierr.Internal("failed to update units", err)
+		}
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Units updated", "base_unit": req.BaseUnit, "units": req.Units})
+}
+
+func (h *Product) GetUnits(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+	p, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return productError(c, err)
+	}
+
+	rows, err := h.Pool.Query(c.Context(), `SELECT code, factor FROM product_unit WHERE product_id = $1 ORDER BY factor, code`, id)
+	if err != nil {
+		return apierr.Internal("failed to load units", err)
+	}
+	defer rows.Close()
+
+	units := []ProductUnit{}
+	for rows.Next() {
+		var u ProductUnit
+		if err := rows.Scan(&u.Code, &u.Factor); err != nil {
+			return apierr.Internal("failed to load units", err)
+		}
+		units = append(units, u)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load units", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"product_id": id, "base_unit": p.BaseUnit, "units": units})
+}
@@ -0,0 +1,439 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// maxVariants caps how many variants one parent can generate.
+const maxVariants = 100
+
+type ProductOption struct {
+	Name   string   `json:"name" validate:"required,max=50"`
+	Values []string `json:"values" validate:"required,min=1,unique,dive,required,max=50"`
+}
+
+type ProductOptionsRequest struct {
+	Options []ProductOption `json:"options" validate:"required,min=1,unique=Name,dive"`
+}
+
+type VariantResponse struct {
+	ProductResponse
+	Options  map[string]string `json:"options"`
+	Quantity decimal.Decimal   `json:"quantity"`
+}
+
+type WarehouseStock struct {
+	WarehouseID int64           `json:"warehouse_id"`
+	Quantity    decimal.Decimal `json:"quantity"`
+}
+
+type VariantStock struct {
+	ProductID int64           `json:"product_id"`
+	SKU       string          `json:"sku"`
+	Quantity  decimal.Decimal `json:"quantity"`
+}
+
+var errIsVariant = errors.New("product is a variant, use its parent product")
+
+// SetOptions replaces the option definitions (e.g. size, colour) of a parent
+// product. Options are fixed once variants have been generated.
+func (h *Product) SetOptions(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	var req ProductOptionsRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	combinations := 1
+	for _, o := range req.Options {
+		combinations *= len(o.Values)
+	}
+	if combinations > maxVariants {
+		return apierr.BadRequest(fmt.Sprintf("options may produce at most %d variants", maxVariants))
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	variants, err := lockParent(c.Context(), tx, tenantID, int64(id))
+	if err != nil {
+		return productError(c, err)
+	}
+	if variants > 0 {
+		return apierr.Conflict("options cannot change after variants were generated")
+	}
+
+	if _, err := tx.Exec(c.Context(), `DELETE FROM product_option WHERE product_id = $1`, id); err != nil {
+		return apierr.Internal("failed to update options", err)
+	}
+	for i, o := range req.Options {
+		_, err := tx.Exec(
+			c.Context(),
+			`INSERT INTO product_option (tenant_id, product_id, name, position, option_values) VALUES ($1, $2, $3, $4, $5)`,
+			tenantID, id, o.Name, i, o.Values,
+		)
+		if err != nil {
+			return apierr.Internal("failed to update options", err)
+		}
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Options updated", "options": req.Options})
+}
+
+// GenerateVariants creates one variant product per combination of option
+// values that does not exist yet. Variant SKUs are the parent SKU followed by
+// the option values, e.g. TSHIRT-M-RED.
+func (h *Product) GenerateVariants(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	if _, err := lockParent(c.Context(), tx, tenantID, int64(id)); err != nil {
+		return productError(c, err)
+	}
+
+	var name, description, sku, productType, baseUnit string
+	var price decimal.Decimal
+	var serialized bool
+	err = tx.QueryRow(
+		c.Context(),
+		`SELECT name, COALESCE(description, ''), price, COALESCE(sku, ''), type, base_unit, serialized FROM product WHERE id = $1`,
+		id,
+	).Scan(&name, &description, &price, &sku, &productType, &baseUnit, &serialized)
+	if err != nil {
+		return apierr.Internal("failed to load product", err)
+	}
+	if productType == inventory.ProductBundle {
+		return apierr.BadRequest("a bundle cannot have variants")
+	}
+	if sku == "" {
+		sku = fmt.Sprintf("P%d", id)
+	}
+
+	type option struct {
+		id     int64
+		values []string
+	}
+	var options []option
+	rows, err := tx.Query(c.Context(), `SELECT id, option_values FROM product_option WHERE product_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return apierr.Internal("failed to load options", err)
+	}
+	for rows.Next() {
+		var o option
+		if err := rows.Scan(&o.id, &o.values); err != nil {
+			rows.Close()
+			return apierr.Internal("failed to load options", err)
+		}
+		options = append(options, o)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load options", rows.Err())
+	}
+	if len(options) == 0 {
+		return apierr.BadRequest("define options before generating variants")
+	}
+
+	// existing combinations, keyed by their values in option order
+	existing := map[string]bool{}
+	rows, err = tx.Query(
+		c.Context(),
+		`SELECT string_agg(vo.value, '/' ORDER BY po.position)
+		FROM product v
+		JOIN product_variant_option vo ON vo.variant_id = v.id
+		JOIN product_option po ON po.id = vo.option_id
+		WHERE v.parent_id = $1
+		GROUP BY v.id`,
+		id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to load variants", err)
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return apierr.Internal("failed to load variants", err)
+		}
+		existing[key] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load variants", rows.Err())
+	}
+
+	combinations := [][]string{{}}
+	for _, o := range options {
+		var next [][]string
+		for _, combo := range combinations {
+			for _, v := range o.values {
+				next = append(next, append(append([]string(nil), combo...), v))
+			}
+		}
+		combinations = next
+	}
+
+	created := []fiber.Map{}
+	for _, combo := range combinations {
+		if existing[strings.Join(combo, "/")] {
+			continue
+		}
+
+		parts := []string{sku}
+		for _, v := range combo {
+			parts = append(parts, strings.ToUpper(strings.Join(strings.Fields(v), "-")))
+		}
+		variantSKU := strings.Join(parts, "-")
+
+		var variantID int64
+		err := tx.QueryRow(
+			c.Context(),
+			`INSERT INTO product (tenant_id, parent_id, name, description, price, sku, base_unit, serialized) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			tenantID, id, name+" / "+strings.Join(combo, " / "), description, price, variantSKU, baseUnit, serialized,
+		).Scan(&variantID)
+		if isUniqueViolation(err) {
+			return apierr.Conflict("sku already exists").With("sku", variantSKU)
+		}
+		if err != nil {
+			return apierr.Internal("failed to create variant", err)
+		}
+
+		for i, v := range combo {
+			_, err := tx.Exec(
+				c.Context(),
+				`INSERT INTO product_variant_option (variant_id, option_id, value) VALUES ($1, $2, $3)`,
+				variantID, options[i].id, v,
+			)
+			if err != nil {
+				return apierr.Internal("failed to create variant", err)
+			}
+		}
+		// variants start out with the parent's pack sizes
+		_, err = tx.Exec(
+			c.Context(),
+			`INSERT INTO product_unit (product_id, code, factor) SELECT $1, code, factor FROM product_unit WHERE product_id = $2`,
+			variantID, id,
+		)
+		if err != nil {
+			return apierr.Internal("failed to create variant", err)
+		}
+		created = append(created, fiber.Map{"id": variantID, "sku": variantSKU})
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Variants generated",
+		"variants": created,
+	})
+}
+
+func (h *Product) ListVariants(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+	if _, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id); err != nil {
+		return productError(c, err)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT `+productColumns+`,
+			COALESCE((SELECT SUM(s.quantity) FROM stock s WHERE s.product_id = product.id AND s.tenant_id = product.tenant_id), 0)
+		FROM product
+		WHERE tenant_id = $1 AND parent_id = $2 AND archived_date IS NULL
+		ORDER BY id`,
+		tenantID, id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to list variants", err)
+	}
+	defer rows.Close()
+
+	variants := []VariantResponse{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var v VariantResponse
+		err := rows.Scan(
+			&v.ID, &v.ParentID, &v.Type, &v.Name, &v.Description, &v.Price, &v.PriceOverride, &v.SKU, &v.BaseUnit, &v.Serialized,
+			&v.ArchivedDate, &v.CreateDate, &v.UpdateDate, &v.Quantity,
+		)
+		if err != nil {
+			return apierr.Internal("failed to list variants", err)
+		}
+		v.Options = map[string]string{}
+		index[v.ID] = len(variants)
+		variants = append(variants, v)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list variants", rows.Err())
+	}
+	rows.Close()
+
+	optionRows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT vo.variant_id, po.name, vo.value
+		FROM product_variant_option vo
+		JOIN product_option po ON po.id = vo.option_id
+		WHERE po.product_id = $1`,
+		id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to list variants", err)
+	}
+	defer optionRows.Close()
+	for optionRows.Next() {
+		var variantID int64
+		var name, value string
+		if err := optionRows.Scan(&variantID, &name, &value); err != nil {
+			return apierr.Internal("failed to list variants", err)
+		}
+		if i, ok := index[variantID]; ok {
+			variants[i].Options[name] = value
+		}
+	}
+	if optionRows.Err() != nil {
+		return apierr.Internal("failed to list variants", optionRows.Err())
+	}
+
+	return c.JSON(fiber.Map{"variants": variants})
+}
+
+// StockTotals returns the stock of a product summed over its variants, per
+// warehouse and per variant. Products without variants report their own stock.
+func (h *Product) StockTotals(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid product ID")
+	}
+	if _, err := h.find(c.Context(), `tenant_id = $1 AND id = $2`, tenantID, id); err != nil {
+		return productError(c, err)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT s.warehouse_id, p.id, COALESCE(p.sku, ''), s.quantity
+		FROM stock s
+		JOIN product p ON p.id = s.product_id
+		WHERE s.tenant_id = $1 AND (p.id = $2 OR p.parent_id = $2)
+		ORDER BY s.warehouse_id, p.id`,
+		tenantID, id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to load stock", err)
+	}
+	defer rows.Close()
+
+	total := decimal.Zero
+	warehouses := []WarehouseStock{}
+	variants := []VariantStock{}
+	warehouseIndex := map[int64]int{}
+	variantIndex := map[int64]int{}
+	for rows.Next() {
+		var warehouseID, productID int64
+		var sku string
+		var quantity decimal.Decimal
+		if err := rows.Scan(&warehouseID, &productID, &sku, &quantity); err != nil {
+			return apierr.Internal("failed to load stock", err)
+		}
+		total = total.Add(quantity)
+
+		i, ok := warehouseIndex[warehouseID]
+		if !ok {
+			i = len(warehouses)
+			warehouseIndex[warehouseID] = i
+			warehouses = append(warehouses, WarehouseStock{WarehouseID: warehouseID})
+		}
+		warehouses[i].Quantity = warehouses[i].Quantity.Add(quantity)
+
+		j, ok := variantIndex[productID]
+		if !ok {
+			j = len(variants)
+			variantIndex[productID] = j
+			variants = append(variants, VariantStock{ProductID: productID, SKU: sku})
+		}
+		variants[j].Quantity = variants[j].Quantity.Add(quantity)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load stock", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{
+		"product_id": id,
+		"quantity":   total,
+		"warehouses": warehouses,
+		"variants":   variants,
+	})
+}
+
+// lockParent locks a parent product of the tenant and returns how many
+// variants it has.
+func lockParent(ctx context.Context, tx pgx.Tx, tenantID, id int64) (int, error) {
+	var parentID *int64
+	err := tx.QueryRow(
+		ctx,
+		`SELECT parent_id FROM product WHERE tenant_id = $1 AND id = $2 AND archived_date IS NULL FOR UPDATE`,
+		tenantID, id,
+	).Scan(&parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errProductNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if parentID != nil {
+		return 0, errIsVariant
+	}
+
+	var variants int
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM product WHERE parent_id = $1`, id).Scan(&variants)
+	return variants, err
+}
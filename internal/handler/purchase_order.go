@@ -0,0 +1,364 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"errors"
+	"time"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/numeric"
+	tasks "atlasq/internal/tasks"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// PurchaseOrder serves purchase orders and the goods receipts against them.
+type PurchaseOrder struct {
+	Pool *pgxpool.Pool
+}
+
+// PurchaseOrderLineRequest is entered in Unit, or the product's base unit
+// when it is empty; the line is stored in the base unit.
+type PurchaseOrderLineRequest struct {
+	ProductID int64           `json:"product_id" validate:"required"`
+	Quantity  decimal.Decimal `json:"quantity" validate:"gt=0,scale=4"`
+	Unit      string          `json:"unit" validate:"max=20"`
+	UnitCost  decimal.Decimal `json:"unit_cost" validate:"gte=0,scale=4"`
+}
+
+type PurchaseOrderRequest struct {
+	SupplierID       int64                      `json:"supplier_id" validate:"required"`
+	WarehouseID      int64                      `json:"warehouse_id" validate:"required"`
+	Reference        string                     `json:"reference" validate:"max=100"`
+	ExpectedDate     string                     `json:"expected_date" validate:"omitempty,datetime=2006-01-02"` // optional, YYYY-MM-DD
+	TolerancePercent decimal.Decimal            `json:"tolerance_percent" validate:"gte=0,lte=100,scale=4"`
+	Lines            []PurchaseOrderLineRequest `json:"lines" validate:"required,min=1,unique=ProductID,dive"`
+}
+
+type ReceiptLineRequest struct {
+	LineID     int64            `json:"line_id" validate:"required"`
+	Quantity   decimal.Decimal  `json:"quantity" validate:"gt=0,scale=4"`
+	Unit       string           `json:"unit" validate:"max=20"`
+	UnitCost   *decimal.Decimal `json:"unit_cost" validate:"omitempty,gte=0,scale=4"`          // optional, defaults to the ordered cost
+	LotNumber  string           `json:"lot_number" validate:"required_with=ExpiryDate,max=50"` // optional
+	ExpiryDate string           `json:"expiry_date" validate:"omitempty,datetime=2006-01-02"`  // optional, YYYY-MM-DD, with lot_number only
+	Serials    []string         `json:"serials"`
+}
+
+type ReceiptRequest struct {
+	Lines []ReceiptLineRequest `json:"lines" validate:"required,min=1,dive"`
+}
+
+type PurchaseOrderLineResponse struct {
+	ID        int64           `json:"id"`
+	ProductID int64           `json:"product_id"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Received  decimal.Decimal `json:"received"`
+	UnitCost  decimal.Decimal `json:"unit_cost"`
+}
+
+type PurchaseOrderResponse struct {
+	ID               int64                       `json:"id"`
+	SupplierID       int64                       `json:"supplier_id"`
+	WarehouseID      int64                       `json:"warehouse_id"`
+	Reference        string                      `json:"reference"`
+	Status           string                      `json:"status"`
+	TolerancePercent decimal.Decimal             `json:"tolerance_percent"`
+	ExpectedDate     *time.Time                  `json:"expected_date"`
+	ClosedDate       *time.Time                  `json:"closed_date"`
+	CreateDate       time.Time                   `json:"create_date"`
+	Lines            []PurchaseOrderLineResponse `json:"lines,omitempty"`
+}
+
+const purchaseOrderColumns = `id, supplier_id, warehouse_id, COALESCE(reference, ''), status, tolerance_percent, expected_date, closed_date, create_date`
+
+func (h *PurchaseOrder) Register(app fiber.Router) {
+	app.Post("/api/v1/purchase-orders", h.Create)
+	app.Get("/api/v1/purchase-orders", h.List)
+	app.Get("/api/v1/purchase-orders/:id", h.Get)
+	app.Post("/api/v1/purchase-orders/:id/receipts", h.Receive)
+	app.Post("/api/v1/purchase-orders/:id/close", h.Close)
+}
+
+func (h *PurchaseOrder) Create(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req PurchaseOrderRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	var expectedDate *time.Time
+	if req.ExpectedDate != "" {
+		d, _ := time.Parse("2006-01-02", req.ExpectedDate)
+		expectedDate = &d
+	}
+
+	items := make([]tasks.OrderItem, 0, len(req.Lines))
+	productIDs := make([]int64, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		items = append(items, tasks.OrderItem{ProductID: line.ProductID, Quantity: line.Quantity, Unit: line.Unit})
+		productIDs = append(productIDs, line.ProductID)
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	var supplierExists bool
+	err = tx.QueryRow(
+		c.Context(),
+		`SELECT EXISTS(SELECT 1 FROM supplier WHERE id = $1 AND tenant_id = $2 AND archived_date IS NULL)`,
+		req.SupplierID, tenantID,
+	).Scan(&supplierExists)
+	if err != nil {
+		return apierr.Internal("failed to load supplier", err)
+	}
+	if !supplierExists {
+		return apierr.BadRequest("supplier not found")
+	}
+	for _, productID := range productIDs {
+		if err := inventory.CheckStockable(c.Context(), tx, tenantID, productID); err != nil {
+			return purchaseOrderError(c, err)
+		}
+	}
+	units, err := inventory.LoadUnits(c.Context(), tx, tenantID, productIDs)
+	if err != nil {
+		return apierr.Internal("failed to load units", err)
+	}
+	items, err = inventory.ToBase(items, units)
+	if err != nil {
+		return purchaseOrderError(c, err)
+	}
+
+	var id int64
+	err = tx.QueryRow(
+		c.Context(),
+		`INSERT INTO purchase_order (tenant_id, supplier_id, warehouse_id, reference, status, tolerance_percent, expected_date)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7) RETURNING id`,
+		tenantID, req.SupplierID, req.WarehouseID, req.Reference, inventory.PurchaseOrderOpen, req.TolerancePercent, expectedDate,
+	).Scan(&id)
+	if err != nil {
+		return apierr.Internal("failed to insert purchase order", err)
+	}
+	for i, item := range items {
+		// the ordered cost is kept per base unit like the quantity
+		factor, _ := units[item.ProductID].Factor(item.Unit)
+		unitCost := req.Lines[i].UnitCost.Div(factor).Round(numeric.MoneyScale)
+		_, err = tx.Exec(
+			c.Context(),
+			`INSERT INTO purchase_order_line (purchase_order_id, product_id, quantity, unit_cost) VALUES ($1, $2, $3, $4)`,
+			id, item.ProductID, item.Quantity, unitCost,
+		)
+		if err != nil {
+			return apierr.Internal("failed to insert purchase order line", err)
+		}
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Purchase order created",
+		"id":      id,
+	})
+}
+
+// List filters purchase orders by ?status= and ?supplier_id=.
+func (h *PurchaseOrder) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	status := c.Query("status")
+	switch status {
+	case "", inventory.PurchaseOrderOpen, inventory.PurchaseOrderPartial, inventory.PurchaseOrderReceived, inventory.PurchaseOrderClosed:
+	default:
+		return apierr.BadRequest("status must be OPEN, PARTIAL, RECEIVED or CLOSED")
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT `+purchaseOrderColumns+` FROM purchase_order
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2) AND ($3 = 0 OR supplier_id = $3)
+		ORDER BY id DESC
+		LIMIT 1000`,
+		tenantID, status, c.QueryInt("supplier_id"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to list purchase orders", err)
+	}
+	defer rows.Close()
+
+	orders := []PurchaseOrderResponse{}
+	for rows.Next() {
+		var po PurchaseOrderResponse
+		if err := scanPurchaseOrder(rows, &po); err != nil {
+			return apierr.Internal("failed to list purchase orders", err)
+		}
+		orders = append(orders, po)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list purchase orders", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"purchase_orders": orders})
+}
+
+func (h *PurchaseOrder) Get(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid purchase order ID")
+	}
+
+	var po PurchaseOrderResponse
+	err = scanPurchaseOrder(h.Pool.QueryRow(
+		c.Context(),
+		`SELECT `+purchaseOrderColumns+` FROM purchase_order WHERE tenant_id = $1 AND id = $2`,
+		tenantID, id,
+	), &po)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierr.NotFound(inventory.ErrPurchaseOrderNotFound.Error())
+	}
+	if err != nil {
+		return apierr.Internal("failed to load purchase order", err)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT id, product_id, quantity, received, unit_cost FROM purchase_order_line WHERE purchase_order_id = $1 ORDER BY id`,
+		id,
+	)
+	if err != nil {
+		return apierr.Internal("failed to load purchase order", err)
+	}
+	defer rows.Close()
+
+	po.Lines = []PurchaseOrderLineResponse{}
+	for rows.Next() {
+		var l PurchaseOrderLineResponse
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.Quantity, &l.Received, &l.UnitCost); err != nil {
+			return apierr.Internal("failed to load purchase order", err)
+		}
+		po.Lines = append(po.Lines, l)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load purchase order", rows.Err())
+	}
+
+	return c.JSON(po)
+}
+
+// Receive books a goods receipt against the purchase order. Lines may be
+// received partially over several receipts.
+func (h *PurchaseOrder) Receive(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid purchase order ID")
+	}
+
+	var req ReceiptRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	lines := make([]inventory.ReceiptLine, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		var expiryDate *time.Time
+		if line.ExpiryDate != "" {
+			d, _ := time.Parse("2006-01-02", line.ExpiryDate)
+			expiryDate = &d
+		}
+		lines = append(lines, inventory.ReceiptLine{
+			LineID:     line.LineID,
+			Quantity:   line.Quantity,
+			Unit:       line.Unit,
+			UnitCost:   line.UnitCost,
+			LotNumber:  line.LotNumber,
+			ExpiryDate: expiryDate,
+			Serials:    line.Serials,
+		})
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	receipt, err := inventory.ReceivePurchaseOrder(c.Context(), tx, tenantID, int64(id), lines)
+	if err != nil {
+		return purchaseOrderError(c, err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(receipt)
+}
+
+// Close stops further receipts, e.g. when the supplier will not ship the
+// rest of a partially received order.
+func (h *PurchaseOrder) Close(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid purchase order ID")
+	}
+
+	var status string
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`UPDATE purchase_order SET status = $1, closed_date = CURRENT_TIMESTAMP, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE id = $2 AND tenant_id = $3 AND status IN ($4, $5)
+		RETURNING status`,
+		inventory.PurchaseOrderClosed, id, tenantID, inventory.PurchaseOrderOpen, inventory.PurchaseOrderPartial,
+	).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		err = h.Pool.QueryRow(
+			c.Context(),
+			`SELECT EXISTS(SELECT 1 FROM purchase_order WHERE id = $1 AND tenant_id = $2)`,
+			id, tenantID,
+		).Scan(&exists)
+		if err == nil && !exists {
+			return purchaseOrderError(c, inventory.ErrPurchaseOrderNotFound)
+		}
+		if err == nil {
+			return purchaseOrderError(c, inventory.ErrPurchaseOrderClosed)
+		}
+	}
+	if err != nil {
+		return apierr.Internal("failed to close purchase order", err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Purchase order closed", "status": status})
+}
+
+func scanPurchaseOrder(row pgx.Row, po *PurchaseOrderResponse) error {
+	return row.Scan(&po.ID, &po.SupplierID, &po.WarehouseID, &po.Reference, &po.Status, &po.TolerancePercent, &po.ExpectedDate, &po.ClosedDate, &po.CreateDate)
+}
+
+// purchaseOrderError maps the errors of creating and receiving purchase
+// orders to responses.
+func purchaseOrderError(c *fiber.Ctx, err error) error {
+	return InventoryError(err, "failed to process purchase order")
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"time"
+
+	"atlasq/internal/inventory"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Serial looks up the units of serialized products.
+type Serial struct {
+	Pool *pgxpool.Pool
+}
+
+type SerialResponse struct {
+	ID          int64     `json:"id"`
+	ProductID   int64     `json:"product_id"`
+	StockID     int64     `json:"stock_id"`
+	WarehouseID int64     `json:"warehouse_id"`
+	Serial      string    `json:"serial"`
+	Status      string    `json:"status"`
+	UpdateDate  time.Time `json:"update_date"`
+}
+
+func (h *Serial) Register(app fiber.Router) {
+	app.Get("/api/v1/serials", h.List)
+}
+
+// List filters serials by ?product_id=, ?warehouse_id=, ?status= and an
+// exact ?serial=.
+func (h *Serial) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	status := c.Query("status")
+	switch status {
+	case "", inventory.SerialInStock, inventory.SerialReserved, inventory.SerialSold, inventory.SerialReturned:
+	default:
+		return apierr.BadRequest("status must be IN_STOCK, RESERVED, SOLD or RETURNED")
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT sn.id, sn.product_id, sn.stock_id, s.warehouse_id, sn.serial, sn.status, sn.update_date
+		FROM serial_number sn
+		JOIN stock s ON s.id = sn.stock_id
+		WHERE sn.tenant_id = $1
+			AND ($2 = 0 OR sn.product_id = $2)
+			AND ($3 = 0 OR s.warehouse_id = $3)
+			AND ($4 = '' OR sn.status = $4)
+			AND ($5 = '' OR sn.serial = $5)
+		ORDER BY sn.id
+		LIMIT 1000`,
+		tenantID, c.QueryInt("product_id"), c.QueryInt("warehouse_id"), status, c.Query("serial"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to list serials", err)
+	}
+	defer rows.Close()
+
+	serials := []SerialResponse{}
+	for rows.Next() {
+		var s SerialResponse
+		if err := rows.Scan(&s.ID, &s.ProductID, &s.StockID, &s.WarehouseID, &s.Serial, &s.Status, &s.UpdateDate); err != nil {
+			return apierr.Internal("failed to list serials", err)
+		}
+		serials = append(serials, s)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list serials", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"serials": serials})
+}
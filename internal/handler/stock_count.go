@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"atlasq/internal/admin"
+	"atlasq/internal/apierr"
+	"errors"
+	"net/http"
+	"time"
+
+	"atlasq/internal/inventory"
+	"atlasq/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// StockCount serves cycle counts: open a count, submit counted quantities,
+// review the variances and post them as ADJUST ledger rows.
+type StockCount struct {
+	Pool *pgxpool.Pool
+}
+
+type StockCountRequest struct {
+	WarehouseID int64   `json:"warehouse_id" validate:"required"`
+	ProductIDs  []int64 `json:"product_ids"` // optional, defaults to every product in the warehouse
+	Freeze      bool    `json:"freeze"`
+}
+
+type CountedLineRequest struct {
+	ProductID int64           `json:"product_id" validate:"required"`
+	Counted   decimal.Decimal `json:"counted" validate:"gte=0,scale=4"`
+	Reason    string          `json:"reason" validate:"omitempty,oneof=DAMAGE SHRINKAGE FOUND"` // DAMAGE or SHRINKAGE for a loss, FOUND for a surplus
+}
+
+type CountedLinesRequest struct {
+	Lines []CountedLineRequest `json:"lines" validate:"required,min=1,dive"`
+}
+
+// Variance is counted minus expected; posting applies it to current stock.
+type StockCountLineResponse struct {
+	ProductID int64            `json:"product_id"`
+	StockID   int64            `json:"stock_id"`
+	Expected  decimal.Decimal  `json:"expected"`
+	Counted   *decimal.Decimal `json:"counted"`
+	Variance  *decimal.Decimal `json:"variance"`
+	Current   decimal.Decimal  `json:"current"`
+	Reason    *string          `json:"reason"`
+}
+
+type StockCountResponse struct {
+	ID          int64                    `json:"id"`
+	WarehouseID int64                    `json:"warehouse_id"`
+	Status      string                   `json:"status"`
+	Freeze      bool                     `json:"freeze"`
+	ApprovedBy  *string                  `json:"approved_by"`
+	PostedDate  *time.Time               `json:"posted_date"`
+	CreateDate  time.Time                `json:"create_date"`
+	Lines       []StockCountLineResponse `json:"lines,omitempty"`
+}
+
+const stockCountColumns = `id, warehouse_id, status, freeze, approved_by, posted_date, create_date`
+
+func (h *StockCount) Register(app fiber.Router) {
+	app.Post("/api/v1/stock-counts", h.Open)
+	app.Get("/api/v1/stock-counts", h.List)
+	app.Get("/api/v1/stock-counts/:id", h.Get)
+	app.Put("/api/v1/stock-counts/:id/lines", h.Submit)
+	app.Post("/api/v1/stock-counts/:id/post", h.Post)
+	app.Post("/api/v1/stock-counts/:id/cancel", h.Cancel)
+}
+
+func (h *StockCount) Open(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req StockCountRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	productIDs := []int64{}
+	seen := map[int64]bool{}
+	for _, id := range req.ProductIDs {
+		if !seen[id] {
+			seen[id] = true
+			productIDs = append(productIDs, id)
+		}
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	id, err := inventory.OpenCount(c.Context(), tx, tenantID, req.WarehouseID, productIDs, req.Freeze)
+	if err != nil {
+		return stockCountError(c, err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Stock count opened",
+		"id":      id,
+	})
+}
+
+// List filters counts by ?status= and ?warehouse_id=.
+func (h *StockCount) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	status := c.Query("status")
+	switch status {
+	case "", inventory.CountOpen, inventory.CountPosted, inventory.CountCancelled:
+	default:
+		return apierr.BadRequest("status must be OPEN, POSTED or CANCELLED")
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT `+stockCountColumns+` FROM stock_count
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2) AND ($3 = 0 OR warehouse_id = $3)
+		ORDER BY id DESC
+		LIMIT 1000`,
+		tenantID, status, c.QueryInt("warehouse_id"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to list stock counts", err)
+	}
+	defer rows.Close()
+
+	counts := []StockCountResponse{}
+	for rows.Next() {
+		var sc StockCountResponse
+		if err := scanStockCount(rows, &sc); err != nil {
+			return apierr.Internal("failed to list stock counts", err)
+		}
+		counts = append(counts, sc)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list stock counts", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"stock_counts": counts})
+}
+
+// Get returns the count with its lines for review. ?variances=true only
+// returns counted lines that differ from the expected quantity.
+func (h *StockCount) Get(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid stock count ID")
+	}
+
+	var sc StockCountResponse
+	err = scanStockCount(h.Pool.QueryRow(
+		c.Context(),
+		`SELECT `+stockCountColumns+` FROM stock_count WHERE tenant_id = $1 AND id = $2`,
+		tenantID, id,
+	), &sc)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return stockCountError(c, inventory.ErrCountNotFound)
+	}
+	if err != nil {
+		return apierr.Internal("failed to load stock count", err)
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT l.product_id, l.stock_id, l.expected, l.counted, l.counted - l.expected, s.quantity, l.reason
+		FROM stock_count_line l
+		JOIN stock s ON s.id = l.stock_id
+		WHERE l.stock_count_id = $1 AND (NOT $2 OR (l.counted IS NOT NULL AND l.counted <> l.expected))
+		ORDER BY l.id`,
+		id, c.QueryBool("variances"),
+	)
+	if err != nil {
+		return apierr.Internal("failed to load stock count", err)
+	}
+	defer rows.Close()
+
+	sc.Lines = []StockCountLineResponse{}
+	for rows.Next() {
+		var l StockCountLineResponse
+		if err := rows.Scan(&l.ProductID, &l.StockID, &l.Expected, &l.Counted, &l.Variance, &l.Current, &l.Reason); err != nil {
+			return apierr.Internal("failed to load stock count", err)
+		}
+		sc.Lines = append(sc.Lines, l)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to load stock count", rows.Err())
+	}
+
+	return c.JSON(sc)
+}
+
+// Submit records counted quantities, in the product's base unit.
+func (h *StockCount) Submit(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid stock count ID")
+	}
+
+	var req CountedLinesRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+	lines := make([]inventory.CountedLine, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lines = append(lines, inventory.CountedLine{ProductID: line.ProductID, Counted: line.Counted, Reason: line.Reason})
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	if err := inventory.SubmitCount(c.Context(), tx, tenantID, int64(id), lines); err != nil {
+		return stockCountError(c, err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.JSON(fiber.Map{"message": "Stock count updated"})
+}
+
+// Post applies the variances to stock. approved_by records the admin user of
+// the request's basic auth. There is a single admin account, ADMIN_USER, so
+// this marks that a count was approved, not who approved it; it is no
+// separation of duties from whoever counted.
+func (h *StockCount) Post(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid stock count ID")
+	}
+	approver, err := countApprover(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	adjusted, err := inventory.PostCount(c.Context(), tx, tenantID, int64(id), approver)
+	if err != nil {
+		return stockCountError(c, err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.JSON(fiber.Map{
+		"message":  "Stock count posted",
+		"adjusted": adjusted,
+	})
+}
+
+// countApprover returns the admin user of the request's basic auth, see
+// admin.CheckBasic; that is always the one configured ADMIN_USER. Counts
+// cannot be posted while no admin is configured.
+func countApprover(c *fiber.Ctx) (string, error) {
+	if !admin.BasicEnabled() {
+		return "", apierr.New(http.StatusForbidden, CodeApprovalRequired, "posting a stock count needs an admin, none is configured")
+	}
+	user, role, ok := admin.CheckBasic(c.Get(fiber.HeaderAuthorization))
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="atlasq"`)
+		return "", apierr.Unauthorized("unauthorized")
+	}
+	if role != admin.RoleAdmin {
+		return "", apierr.New(http.StatusForbidden, CodeApprovalRequired, "only an admin can post a stock count")
+	}
+	return user, nil
+}
+
+func (h *StockCount) Cancel(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid stock count ID")
+	}
+
+	tx, err := h.Pool.Begin(c.Context())
+	if err != nil {
+		return apierr.Internal("failed to start transaction", err)
+	}
+	defer tx.Rollback(c.Context())
+
+	if err := inventory.CancelCount(c.Context(), tx, tenantID, int64(id)); err != nil {
+		return stockCountError(c, err)
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		return apierr.Internal("failed to commit transaction", err)
+	}
+	return c.JSON(fiber.Map{"message": "Stock count cancelled"})
+}
+
+func scanStockCount(row pgx.Row, sc *StockCountResponse) error {
+	return row.Scan(&sc.ID, &sc.WarehouseID, &sc.Status, &sc.Freeze, &sc.ApprovedBy, &sc.PostedDate, &sc.CreateDate)
+}
+
+// stockCountError maps the errors of the count workflow to responses.
+func stockCountError(c *fiber.Ctx, err error) error {
+	return InventoryError(err, "failed to process stock count")
+}
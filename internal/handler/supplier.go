@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"atlasq/internal/apierr"
+	"atlasq/internal/validation"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Supplier serves the suppliers purchase orders are placed with.
+type Supplier struct {
+	Pool *pgxpool.Pool
+}
+
+type SupplierRequest struct {
+	Name  string `json:"name" validate:"required,max=200"`
+	Email string `json:"email" validate:"max=255"`
+	Phone string `json:"phone" validate:"max=50"`
+}
+
+type SupplierResponse struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Phone      string    `json:"phone"`
+	CreateDate time.Time `json:"create_date"`
+}
+
+const supplierColumns = `id, name, COALESCE(email, ''), COALESCE(phone, ''), create_date`
+
+func (h *Supplier) Register(app fiber.Router) {
+	app.Post("/api/v1/suppliers", h.Create)
+	app.Get("/api/v1/suppliers", h.List)
+	app.Get("/api/v1/suppliers/:id", h.Get)
+}
+
+func (h *Supplier) Create(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	var req SupplierRequest
+	if err := validation.Body(c, &req); err != nil {
+		return err
+	}
+
+	var id int64
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`INSERT INTO supplier (tenant_id, name, email, phone) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, '')) RETURNING id`,
+		tenantID, req.Name, req.Email, req.Phone,
+	).Scan(&id)
+	if isUniqueViolation(err) {
+		return apierr.Conflict("supplier already exists").With("name", req.Name)
+	}
+	if err != nil {
+		return apierr.Internal("failed to insert supplier", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Supplier created",
+		"id":      id,
+		"name":    req.Name,
+	})
+}
+
+func (h *Supplier) List(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.Pool.Query(
+		c.Context(),
+		`SELECT `+supplierColumns+` FROM supplier WHERE tenant_id = $1 AND archived_date IS NULL ORDER BY name`,
+		tenantID,
+	)
+	if err != nil {
+		return apierr.Internal("failed to list suppliers", err)
+	}
+	defer rows.Close()
+
+	suppliers := []SupplierResponse{}
+	for rows.Next() {
+		var s SupplierResponse
+		if err := rows.Scan(&s.ID, &s.Name, &s.Email, &s.Phone, &s.CreateDate); err != nil {
+			return apierr.Internal("failed to list suppliers", err)
+		}
+		suppliers = append(suppliers, s)
+	}
+	if rows.Err() != nil {
+		return apierr.Internal("failed to list suppliers", rows.Err())
+	}
+
+	return c.JSON(fiber.Map{"suppliers": suppliers})
+}
+
+func (h *Supplier) Get(c *fiber.Ctx) error {
+	tenantID, err := queryTenant(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apierr.BadRequest("invalid supplier ID")
+	}
+
+	var s SupplierResponse
+	err = h.Pool.QueryRow(
+		c.Context(),
+		`SELECT `+supplierColumns+` FROM supplier WHERE tenant_id = $1 AND id = $2`,
+		tenantID, id,
+	).Scan(&s.ID, &s.Name, &s.Email, &s.Phone, &s.CreateDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierr.NotFound("supplier not found")
+	}
+	if err != nil {
+		return apierr.Internal("failed to load supplier", err)
+	}
+	return c.JSON(s)
+}
@@ -0,0 +1,126 @@
+// Package health runs the dependency checks behind the /readyz endpoints of
+// the API and the worker.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"atlasq/internal/logger"
+	"atlasq/internal/migration"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// CheckTimeout bounds each check, so a hung dependency fails readiness
+// instead of hanging the probe.
+const CheckTimeout = 2 * time.Second
+
+// Check is one dependency readiness depends on.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Report is the body of /readyz. Checks maps each check to "ok" or
+// "unavailable"; the cause is only logged, the probe is unauthenticated.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Ready runs checks and reports whether all of them passed.
+func Ready(ctx context.Context, checks []Check) (Report, bool) {
+	report := Report{Status: "ok", Checks: make(map[string]string, len(checks))}
+	ready := true
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, CheckTimeout)
+		err := check.Run(checkCtx)
+		cancel()
+		if err != nil {
+			logger.L().Warn("readiness check failed", zap.String("check", check.Name), zap.Error(err))
+			report.Checks[check.Name] = "unavailable"
+			ready = false
+			continue
+		}
+		report.Checks[check.Name] = "ok"
+	}
+	if !ready {
+		report.Status = "unavailable"
+	}
+	return report, ready
+}
+
+// Postgres checks that pool can reach the database.
+func Postgres(pool *pgxpool.Pool) Check {
+	return Check{Name: "postgres", Run: pool.Ping}
+}
+
+// Pinger is anything that can ping Redis, such as an asynq client or server.
+type Pinger interface {
+	Ping() error
+}
+
+// Redis checks that p can reach Redis.
+func Redis(p Pinger) Check {
+	return Check{Name: "redis", Run: func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() { done <- p.Ping() }()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+}
+
+// Schema checks that the database is migrated to the newest embedded
+// migration and not left dirty by a failed one.
+func Schema(pool *pgxpool.Pool) Check {
+	return Check{Name: "schema", Run: func(ctx context.Context) error {
+		want, err := migration.Latest()
+		if err != nil {
+			return err
+		}
+		version, dirty, err := migration.Current(ctx, pool)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("schema version %d is dirty", version)
+		}
+		if version != want {
+			return fmt.Errorf("schema version is %d, want %d", version, want)
+		}
+		return nil
+	}}
+}
+
+// Handler serves /healthz, which answers as long as the process does, and
+// /readyz, which runs checks, for processes without a Fiber app.
+func Handler(checks []Check) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report, ready := Ready(r.Context(), checks)
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,142 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// AlertDeliveryAttempts is how often a low-stock webhook is tried before the
+// alert is only kept in the alert list.
+const AlertDeliveryAttempts = 10
+
+// StockAlert is a low-stock alert, open until its stock row is restocked
+// above the minimum.
+type StockAlert struct {
+	ID            int64           `json:"id"`
+	TenantID      int64           `json:"tenant_id"`
+	StockID       int64           `json:"stock_id"`
+	ProductID     int64           `json:"product_id"`
+	WarehouseID   int64           `json:"warehouse_id"`
+	Minimum       decimal.Decimal `json:"minimum"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	CreateDate    time.Time       `json:"create_date"`
+	DeliveredDate *time.Time      `json:"delivered_date"`
+	ResolvedDate  *time.Time      `json:"resolved_date"`
+}
+
+// CheckLowStock compares stockID with its minimum after its quantity changed.
+// Falling to or below a positive minimum opens an alert unless one is already
+// open; rising above it resolves the open alert, so the next fall fires again.
+func CheckLowStock(ctx context.Context, tx pgx.Tx, stockID int64) error {
+	_, err := tx.Exec(
+		ctx,
+		`UPDATE stock_alert a SET resolved_date = CURRENT_TIMESTAMP, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		FROM stock s
+		WHERE a.stock_id = s.id AND s.id = $1 AND a.resolved_date IS NULL AND s.quantity > s.minimum`,
+		stockID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to resolve low-stock alert: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO stock_alert (tenant_id, stock_id, product_id, warehouse_id, minimum, quantity)
+		SELECT tenant_id, id, product_id, warehouse_id, minimum, quantity
+		FROM stock WHERE id = $1 AND minimum > 0 AND quantity <= minimum
+		ON CONFLICT (stock_id) WHERE resolved_date IS NULL DO NOTHING`,
+		stockID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to open low-stock alert: %w", err)
+	}
+	return nil
+}
+
+// PendingAlert is an alert waiting for delivery to its tenant's callback_url.
+type PendingAlert struct {
+	StockAlert
+	CallbackURL string
+	Secret      string
+}
+
+// ClaimAlerts claims up to limit undelivered alerts of tenants with a
+// callback_url, oldest first, for lease. Alerts claimed by another run are
+// skipped until RecordAlertDelivery releases them or the lease runs out.
+func ClaimAlerts(ctx context.Context, q Querier, limit int, lease time.Duration) ([]PendingAlert, error) {
+	rows, err := q.Query(
+		ctx,
+		`UPDATE stock_alert a SET claimed_until = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second', row_update_date = CURRENT_TIMESTAMP
+		FROM tenants t
+		WHERE t.id = a.tenant_id AND a.id IN (
+			SELECT a.id FROM stock_alert a
+			JOIN tenants t ON t.id = a.tenant_id
+			WHERE a.delivered_date IS NULL AND a.delivery_attempts < $1
+				AND (a.claimed_until IS NULL OR a.claimed_until < CURRENT_TIMESTAMP)
+				AND COALESCE(t.callback_url, '') <> '' AND t.deleted_date IS NULL
+			ORDER BY a.id
+			LIMIT $2
+			FOR UPDATE OF a SKIP LOCKED
+		)
+		RETURNING a.id, a.tenant_id, a.stock_id, a.product_id, a.warehouse_id, a.minimum, a.quantity, a.create_date, a.resolved_date,
+			t.callback_url, t.secret`,
+		AlertDeliveryAttempts, limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var alerts []PendingAlert
+	for rows.Next() {
+		var a PendingAlert
+		err := rows.Scan(&a.ID, &a.TenantID, &a.StockID, &a.ProductID, &a.WarehouseID, &a.Minimum, &a.Quantity, &a.CreateDate, &a.ResolvedDate,
+			&a.CallbackURL, &a.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim pending alerts: %w", err)
+	}
+	// RETURNING does not keep the subquery's order
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+	return alerts, nil
+}
+
+// RecordAlertDelivery counts a delivery attempt on the alert and on its
+// tenant's push counters, and releases the claim of ClaimAlerts.
+func RecordAlertDelivery(ctx context.Context, db Execer, alert PendingAlert, delivered bool) error {
+	_, err := db.Exec(
+		ctx,
+		`UPDATE stock_alert SET
+			delivery_attempts = delivery_attempts + 1,
+			delivered_date = CASE WHEN $2 THEN CURRENT_TIMESTAMP END,
+			claimed_until = NULL,
+			update_date = CURRENT_TIMESTAMP,
+			row_update_date = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		alert.ID, delivered,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update alert: %w", err)
+	}
+	_, err = db.Exec(
+		ctx,
+		`UPDATE tenants SET
+			push_total = push_total + 1,
+			push_failed = push_failed + CASE WHEN $2 THEN 0 ELSE 1 END,
+			row_updated_date = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		alert.TenantID, delivered,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant push counters: %w", err)
+	}
+	return nil
+}
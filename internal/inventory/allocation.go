@@ -0,0 +1,260 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Allocation policies stored in allocation_policy.policy.
+const (
+	// AllocatePriority walks the tenant's warehouse_priority list in order.
+	AllocatePriority = "PRIORITY"
+	// AllocateMostStock takes each item from the warehouse holding the most of it.
+	AllocateMostStock = "MOST_STOCK"
+	// AllocateFewestSplits prefers warehouses that can ship whole lines, so the
+	// order is split over as few warehouses as possible.
+	AllocateFewestSplits = "FEWEST_SPLITS"
+)
+
+// DefaultAllocationPolicy is used for tenants without an allocation_policy row.
+const DefaultAllocationPolicy = AllocateMostStock
+
+// Fulfillment is the part of an order shipped from one warehouse.
+type Fulfillment struct {
+	WarehouseID int64  `json:"warehouse_id"`
+	Items       []Line `json:"items"`
+}
+
+// Allocation is the recorded result of splitting an order over warehouses.
+type Allocation struct {
+	ID           int64         `json:"id"`
+	Policy       string        `json:"policy"`
+	Fulfillments []Fulfillment `json:"fulfillments"`
+}
+
+// LoadAllocationPolicy returns the tenant's policy and warehouse priority list.
+func LoadAllocationPolicy(ctx context.Context, tx pgx.Tx, tenantID int64) (string, []int64, error) {
+	var policy string
+	var priority []int64
+	err := tx.QueryRow(
+		ctx,
+		`SELECT policy, warehouse_priority FROM allocation_policy WHERE tenant_id = $1`,
+		tenantID,
+	).Scan(&policy, &priority)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultAllocationPolicy, nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load allocation policy: %w", err)
+	}
+	return policy, priority, nil
+}
+
+// Allocate splits lines over the tenant's warehouses using the tenant's
+// policy and records the result in order_allocation/order_fulfillment.
+// Stock rows are locked but not deducted.
+func Allocate(ctx context.Context, tx pgx.Tx, tenantID int64, items []Line) (*Allocation, error) {
+	policy, priority, err := LoadAllocationPolicy(ctx, tx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]int64, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT warehouse_id, product_id, `+usableQuantity+` FROM stock
+		WHERE tenant_id = $1 AND product_id = ANY($2) AND status = true AND quantity > 0
+			AND frozen_count_id IS NULL
+		ORDER BY warehouse_id
+		FOR UPDATE`,
+		tenantID, productIDs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stock for allocation: %w", err)
+	}
+	stock := map[int64]map[int64]decimal.Decimal{}
+	for rows.Next() {
+		var warehouseID, productID int64
+		var quantity decimal.Decimal
+		if err := rows.Scan(&warehouseID, &productID, &quantity); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan stock for allocation: %w", err)
+		}
+		if !quantity.IsPositive() {
+			continue // only expired lots left
+		}
+		if stock[warehouseID] == nil {
+			stock[warehouseID] = map[int64]decimal.Decimal{}
+		}
+		stock[warehouseID][productID] = quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load stock for allocation: %w", err)
+	}
+
+	fulfillments, err := allocate(policy, priority, stock, items)
+	if err != nil {
+		return nil, err
+	}
+
+	alloc := &Allocation{Policy: policy, Fulfillments: fulfillments}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO order_allocation (tenant_id, policy) VALUES ($1, $2) RETURNING id`,
+		tenantID, policy,
+	).Scan(&alloc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record allocation: %w", err)
+	}
+	for _, f := range fulfillments {
+		for _, item := range f.Items {
+			var bundleProductID *int64
+			if item.BundleProductID != 0 {
+				bundleProductID = &item.BundleProductID
+			}
+			_, err := tx.Exec(
+				ctx,
+				`INSERT INTO order_fulfillment (allocation_id, tenant_id, warehouse_id, product_id, quantity, bundle_product_id) VALUES ($1, $2, $3, $4, $5, $6)`,
+				alloc.ID, tenantID, f.WarehouseID, item.ProductID, item.Quantity, bundleProductID,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to record fulfillment: %w", err)
+			}
+		}
+	}
+	return alloc, nil
+}
+
+// allocate is the pure part of Allocate. stock maps warehouse → product →
+// available quantity and is consumed as lines are assigned.
+func allocate(policy string, priority []int64, stock map[int64]map[int64]decimal.Decimal, items []Line) ([]Fulfillment, error) {
+	warehouses := make([]int64, 0, len(stock))
+	for id := range stock {
+		warehouses = append(warehouses, id)
+	}
+	sort.Slice(warehouses, func(i, j int) bool { return warehouses[i] < warehouses[j] })
+
+	var order []int64
+	byWarehouse := map[int64][]Line{}
+	assign := func(warehouseID int64, item Line, quantity decimal.Decimal) {
+		if _, ok := byWarehouse[warehouseID]; !ok {
+			order = append(order, warehouseID)
+		}
+		item.Quantity = quantity
+		byWarehouse[warehouseID] = append(byWarehouse[warehouseID], item)
+		stock[warehouseID][item.ProductID] = stock[warehouseID][item.ProductID].Sub(quantity)
+	}
+
+	// split takes one line greedily from the warehouses in the given order.
+	split := func(item Line, candidates []int64) error {
+		total := decimal.Zero
+		for _, w := range candidates {
+			total = total.Add(stock[w][item.ProductID])
+		}
+		if total.LessThan(item.Quantity) {
+			return &InsufficientStockError{ProductID: item.ProductID, Stock: total, Required: item.Quantity}
+		}
+		remaining := item.Quantity
+		for _, w := range candidates {
+			if !remaining.IsPositive() {
+				break
+			}
+			take := decimal.Min(stock[w][item.ProductID], remaining)
+			if take.IsPositive() {
+				assign(w, item, take)
+				remaining = remaining.Sub(take)
+			}
+		}
+		return nil
+	}
+
+	mostStockFirst := func(productID int64) []int64 {
+		candidates := append([]int64(nil), warehouses...)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return stock[candidates[i]][productID].GreaterThan(stock[candidates[j]][productID])
+		})
+		return candidates
+	}
+
+	switch policy {
+	case AllocatePriority:
+		// listed warehouses first, in the tenant's order, then the rest by id
+		candidates := make([]int64, 0, len(warehouses))
+		listed := map[int64]bool{}
+		for _, w := range priority {
+			if _, ok := stock[w]; ok && !listed[w] {
+				candidates = append(candidates, w)
+				listed[w] = true
+			}
+		}
+		for _, w := range warehouses {
+			if !listed[w] {
+				candidates = append(candidates, w)
+			}
+		}
+		for _, item := range items {
+			if err := split(item, candidates); err != nil {
+				return nil, err
+			}
+		}
+
+	case AllocateFewestSplits:
+		pending := append([]Line(nil), items...)
+		for len(pending) > 0 {
+			// pick the warehouse that can ship the most whole lines
+			best, bestLines := int64(0), 0
+			for _, w := range warehouses {
+				lines := 0
+				for _, item := range pending {
+					if stock[w][item.ProductID].GreaterThanOrEqual(item.Quantity) {
+						lines++
+					}
+				}
+				if lines > bestLines {
+					best, bestLines = w, lines
+				}
+			}
+			if bestLines == 0 {
+				break
+			}
+			var rest []Line
+			for _, item := range pending {
+				if stock[best][item.ProductID].GreaterThanOrEqual(item.Quantity) {
+					assign(best, item, item.Quantity)
+				} else {
+					rest = append(rest, item)
+				}
+			}
+			pending = rest
+		}
+		// lines no single warehouse can ship are split by stock on hand
+		for _, item := range pending {
+			if err := split(item, mostStockFirst(item.ProductID)); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		for _, item := range items {
+			if err := split(item, mostStockFirst(item.ProductID)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fulfillments := make([]Fulfillment, 0, len(order))
+	for _, w := range order {
+		fulfillments = append(fulfillments, Fulfillment{WarehouseID: w, Items: byWarehouse[w]})
+	}
+	return fulfillments, nil
+}
@@ -0,0 +1,148 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// dec parses a decimal literal of a test table.
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// formatFulfillments writes fulfillments as "w1: p1x5 p2x3; w2: p1x1".
+func formatFulfillments(fulfillments []Fulfillment) string {
+	parts := make([]string, 0, len(fulfillments))
+	for _, f := range fulfillments {
+		items := make([]string, 0, len(f.Items))
+		for _, item := range f.Items {
+			items = append(items, fmt.Sprintf("p%dx%s", item.ProductID, item.Quantity))
+		}
+		parts = append(parts, fmt.Sprintf("w%d: %s", f.WarehouseID, strings.Join(items, " ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestAllocate(t *testing.T) {
+	// warehouse → product → quantity, copied per test since allocate consumes it
+	stock := map[int64]map[int64]string{
+		1: {10: "5", 20: "1"},
+		2: {10: "8", 20: "4"},
+		3: {10: "2", 20: "4", 30: "1"},
+	}
+	tests := []struct {
+		name     string
+		policy   string
+		priority []int64
+		items    []Line
+		want     string
+		short    int64
+	}{
+		{
+			name:   "most stock takes each line from the fullest warehouse, the lowest id on a tie",
+			policy: AllocateMostStock,
+			items:  []Line{{ProductID: 10, Quantity: dec("6")}, {ProductID: 20, Quantity: dec("2")}},
+			want:   "w2: p10x6 p20x2",
+		},
+		{
+			name:   "most stock splits a line no warehouse can ship alone",
+			policy: AllocateMostStock,
+			items:  []Line{{ProductID: 10, Quantity: dec("12")}},
+			want:   "w2: p10x8; w1: p10x4",
+		},
+		{
+			name:   "unknown policies fall back to most stock",
+			policy: "",
+			items:  []Line{{ProductID: 10, Quantity: dec("1")}},
+			want:   "w2: p10x1",
+		},
+		{
+			name:     "priority walks the listed warehouses first",
+			policy:   AllocatePriority,
+			priority: []int64{3, 1},
+			items:    []Line{{ProductID: 10, Quantity: dec("9")}},
+			want:     "w3: p10x2; w1: p10x5; w2: p10x2",
+		},
+		{
+			name:     "priority ignores warehouses without stock rows",
+			policy:   AllocatePriority,
+			priority: []int64{99, 1},
+			items:    []Line{{ProductID: 20, Quantity: dec("1")}},
+			want:     "w1: p20x1",
+		},
+		{
+			name:   "fewest splits ships the whole order from one warehouse",
+			policy: AllocateFewestSplits,
+			items:  []Line{{ProductID: 10, Quantity: dec("2")}, {ProductID: 20, Quantity: dec("3")}, {ProductID: 30, Quantity: dec("1")}},
+			want:   "w3: p10x2 p20x3 p30x1",
+		},
+		{
+			name:   "fewest splits picks the warehouse with the most whole lines first",
+			policy: AllocateFewestSplits,
+			items:  []Line{{ProductID: 10, Quantity: dec("7")}, {ProductID: 20, Quantity: dec("4")}, {ProductID: 30, Quantity: dec("1")}},
+			want:   "w2: p10x7 p20x4; w3: p30x1",
+		},
+		{
+			name:   "fewest splits splits only what no warehouse holds whole",
+			policy: AllocateFewestSplits,
+			items:  []Line{{ProductID: 10, Quantity: dec("9")}, {ProductID: 30, Quantity: dec("1")}},
+			want:   "w3: p30x1; w2: p10x8; w1: p10x1",
+		},
+		{
+			name:   "decimal quantities",
+			policy: AllocateMostStock,
+			items:  []Line{{ProductID: 10, Quantity: dec("8.25")}},
+			want:   "w2: p10x8; w1: p10x0.25",
+		},
+		{
+			name:   "bundle components keep their bundle",
+			policy: AllocateMostStock,
+			items:  []Line{{ProductID: 20, Quantity: dec("2"), BundleProductID: 99}},
+			want:   "w2: p20x2",
+		},
+		{
+			name:   "not enough stock over all warehouses",
+			policy: AllocateMostStock,
+			items:  []Line{{ProductID: 10, Quantity: dec("16")}},
+			short:  10,
+		},
+		{
+			name:   "a product nobody stocks",
+			policy: AllocateFewestSplits,
+			items:  []Line{{ProductID: 40, Quantity: dec("1")}},
+			short:  40,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			available := map[int64]map[int64]decimal.Decimal{}
+			for w, products := range stock {
+				available[w] = map[int64]decimal.Decimal{}
+				for p, q := range products {
+					available[w][p] = dec(q)
+				}
+			}
+			got, err := allocate(tt.policy, tt.priority, available, tt.items)
+			if tt.short != 0 {
+				var stockErr *InsufficientStockError
+				if !errors.As(err, &stockErr) || stockErr.ProductID != tt.short {
+					t.Fatalf("err = %v, want InsufficientStockError for product %d", err, tt.short)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := formatFulfillments(got); s != tt.want {
+				t.Errorf("allocate = %q, want %q", s, tt.want)
+			}
+			if tt.items[0].BundleProductID != 0 && got[0].Items[0].BundleProductID != tt.items[0].BundleProductID {
+				t.Errorf("bundle_product_id = %d, want %d", got[0].Items[0].BundleProductID, tt.items[0].BundleProductID)
+			}
+		})
+	}
+}
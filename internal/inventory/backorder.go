@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+
+	tasks "atlasq/internal/tasks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Backorder statuses stored in backorder.status.
+const (
+	BackorderOpen   = "OPEN"
+	BackorderFilled = "FILLED"
+)
+
+func createBackorder(ctx context.Context, tx pgx.Tx, tenantID, warehouseID int64, allocationID *int64, s Shortage) (int64, error) {
+	// warehouse_id is NULL for allocated orders so any warehouse can fill it
+	var warehouse *int64
+	if warehouseID != 0 {
+		warehouse = &warehouseID
+	}
+
+	var id int64
+	err := tx.QueryRow(
+		ctx,
+		`INSERT INTO backorder (tenant_id, warehouse_id, product_id, allocation_id, quantity, status)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		tenantID, warehouse, s.ProductID, allocationID, s.Short, BackorderOpen,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backorder: %w", err)
+	}
+	return id, nil
+}
+
+// FillBackorders ships open backorders, oldest first, from the stock just
+// received into warehouseID. Backorders for bundles that contain the received
+// product are included. It returns the number of backordered units shipped.
+func FillBackorders(ctx context.Context, tx pgx.Tx, tenantID, warehouseID, productID int64) (decimal.Decimal, error) {
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, product_id, quantity - filled FROM backorder
+		WHERE tenant_id = $1 AND status = $2 AND (warehouse_id IS NULL OR warehouse_id = $3)
+			AND (product_id = $4 OR product_id IN (SELECT bundle_id FROM bundle_component WHERE component_id = $4))
+		ORDER BY id
+		FOR UPDATE`,
+		tenantID, BackorderOpen, warehouseID, productID,
+	)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load backorders: %w", err)
+	}
+
+	type open struct {
+		id        int64
+		productID int64
+		remaining decimal.Decimal
+	}
+	var backorders []open
+	var productIDs []int64
+	for rows.Next() {
+		var b open
+		if err := rows.Scan(&b.id, &b.productID, &b.remaining); err != nil {
+			rows.Close()
+			return decimal.Zero, fmt.Errorf("failed to scan backorder: %w", err)
+		}
+		backorders = append(backorders, b)
+		productIDs = append(productIDs, b.productID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load backorders: %w", err)
+	}
+	if len(backorders) == 0 {
+		return decimal.Zero, nil
+	}
+
+	components, err := LoadComponents(ctx, tx, tenantID, productIDs)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	available, err := availableStock(ctx, tx, tenantID, warehouseID, stockIDs(productIDs, components))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	shipped := decimal.Zero
+	for _, b := range backorders {
+		take := decimal.Min(b.remaining, Available(b.productID, components, available))
+		if !take.IsPositive() {
+			continue
+		}
+
+		lines := Expand([]tasks.OrderItem{{ProductID: b.productID, Quantity: take}}, components, nil)
+		if err := DeductLines(ctx, tx, tenantID, warehouseID, lines); err != nil {
+			return decimal.Zero, err
+		}
+		consume(b.productID, take, components, available)
+
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE backorder SET
+				filled = filled + $1,
+				status = CASE WHEN filled + $1 >= quantity THEN $2 ELSE status END,
+				update_date = CURRENT_TIMESTAMP,
+				row_update_date = CURRENT_TIMESTAMP
+			WHERE id = $3`,
+			take, BackorderFilled, b.id,
+		)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("failed to update backorder: %w", err)
+		}
+		shipped = shipped.Add(take)
+	}
+	return shipped, nil
+}
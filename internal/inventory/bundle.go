@@ -0,0 +1,160 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	tasks "atlasq/internal/tasks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Product types stored in product.type.
+const (
+	ProductSimple = "SIMPLE"
+	// ProductBundle holds no stock itself; ordering it deducts its components.
+	ProductBundle = "BUNDLE"
+)
+
+// ErrBundleStock is returned when stock is received for a bundle.
+var ErrBundleStock = errors.New("bundles hold no stock, receive their components instead")
+
+// Component is one entry of a bundle's bill of materials.
+type Component struct {
+	ProductID int64           `json:"product_id" validate:"required"`
+	Quantity  decimal.Decimal `json:"quantity" validate:"gt=0,scale=4"`
+}
+
+// Querier is the read side shared by *pgxpool.Pool and pgx.Tx.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// LoadComponents returns the bill of materials of every bundle among
+// productIDs. Products that are not bundles are absent from the map.
+func LoadComponents(ctx context.Context, q Querier, tenantID int64, productIDs []int64) (map[int64][]Component, error) {
+	rows, err := q.Query(
+		ctx,
+		`SELECT bc.bundle_id, bc.component_id, bc.quantity
+		FROM bundle_component bc
+		JOIN product p ON p.id = bc.bundle_id
+		WHERE p.tenant_id = $1 AND p.type = $2 AND bc.bundle_id = ANY($3)
+		ORDER BY bc.bundle_id, bc.component_id`,
+		tenantID, ProductBundle, productIDs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bundle components: %w", err)
+	}
+	defer rows.Close()
+
+	components := map[int64][]Component{}
+	for rows.Next() {
+		var bundleID int64
+		var c Component
+		if err := rows.Scan(&bundleID, &c.ProductID, &c.Quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan bundle component: %w", err)
+		}
+		components[bundleID] = append(components[bundleID], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load bundle components: %w", err)
+	}
+	return components, nil
+}
+
+// Expand turns order items, already in their base unit, into stock lines,
+// replacing each bundle by its components multiplied by the ordered quantity.
+// units may be nil when every item was entered in its base unit.
+func Expand(items []tasks.OrderItem, components map[int64][]Component, units map[int64]Units) []Line {
+	var lines []Line
+	for _, item := range items {
+		bom, ok := components[item.ProductID]
+		if !ok {
+			line := Line{ProductID: item.ProductID, Quantity: item.Quantity, Serials: item.Serials}
+			if factor, ok := units[item.ProductID].Factor(item.Unit); ok && item.Unit != "" {
+				line.Unit, line.UnitFactor = item.Unit, factor
+			}
+			lines = append(lines, line)
+			continue
+		}
+		for _, c := range bom {
+			lines = append(lines, Line{
+				ProductID:       c.ProductID,
+				Quantity:        c.Quantity.Mul(item.Quantity),
+				BundleProductID: item.ProductID,
+			})
+		}
+	}
+	return lines
+}
+
+// Available returns how much of productID the available stock covers. For a
+// bundle that is the number of whole sets its scarcest component allows.
+func Available(productID int64, components map[int64][]Component, available map[int64]decimal.Decimal) decimal.Decimal {
+	bom, ok := components[productID]
+	if !ok {
+		return available[productID]
+	}
+	units := decimal.NewFromInt(-1)
+	for _, c := range bom {
+		n := available[c.ProductID].Div(c.Quantity).Floor()
+		if units.IsNegative() || n.LessThan(units) {
+			units = n
+		}
+	}
+	if units.IsNegative() {
+		return decimal.Zero
+	}
+	return units
+}
+
+// consume removes quantity units of productID (or of its components) from
+// the available stock.
+func consume(productID int64, quantity decimal.Decimal, components map[int64][]Component, available map[int64]decimal.Decimal) {
+	bom, ok := components[productID]
+	if !ok {
+		available[productID] = available[productID].Sub(quantity)
+		return
+	}
+	for _, c := range bom {
+		available[c.ProductID] = available[c.ProductID].Sub(c.Quantity.Mul(quantity))
+	}
+}
+
+// stockIDs returns productIDs plus the components of any bundle among them,
+// i.e. every product whose stock rows an order touches.
+func stockIDs(productIDs []int64, components map[int64][]Component) []int64 {
+	ids := make([]int64, 0, len(productIDs))
+	for _, id := range productIDs {
+		bom, ok := components[id]
+		if !ok {
+			ids = append(ids, id)
+			continue
+		}
+		for _, c := range bom {
+			ids = append(ids, c.ProductID)
+		}
+	}
+	return ids
+}
+
+// CheckStockable makes sure stock can be received for productID: it must be
+// an active product of the tenant that is neither a parent with variants nor
+// a bundle.
+func CheckStockable(ctx context.Context, tx pgx.Tx, tenantID, productID int64) error {
+	if err := CheckProducts(ctx, tx, tenantID, []tasks.OrderItem{{ProductID: productID}}); err != nil {
+		return err
+	}
+	var productType string
+	err := tx.QueryRow(ctx, `SELECT type FROM product WHERE id = $1`, productID).Scan(&productType)
+	if err != nil {
+		return fmt.Errorf("failed to load product type: %w", err)
+	}
+	if productType == ProductBundle {
+		return ErrBundleStock
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+
+	tasks "atlasq/internal/tasks"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestExpand(t *testing.T) {
+	// product 1 is a bundle of 2 x product 10 and 0.5 x product 20
+	components := map[int64][]Component{
+		1: {{ProductID: 10, Quantity: dec("2")}, {ProductID: 20, Quantity: dec("0.5")}},
+	}
+	units := map[int64]Units{
+		10: {Base: "PCS", Factors: map[string]decimal.Decimal{"BOX": dec("12")}},
+	}
+	tests := []struct {
+		name  string
+		items []tasks.OrderItem
+		units map[int64]Units
+		want  []Line
+	}{
+		{
+			name:  "simple products pass through",
+			items: []tasks.OrderItem{{ProductID: 10, Quantity: dec("3"), Serials: []string{"A", "B", "C"}}},
+			want:  []Line{{ProductID: 10, Quantity: dec("3"), Serials: []string{"A", "B", "C"}}},
+		},
+		{
+			name:  "a bundle becomes its components times the quantity",
+			items: []tasks.OrderItem{{ProductID: 1, Quantity: dec("3")}},
+			want: []Line{
+				{ProductID: 10, Quantity: dec("6"), BundleProductID: 1},
+				{ProductID: 20, Quantity: dec("1.5"), BundleProductID: 1},
+			},
+		},
+		{
+			name:  "bundles and simple products mixed, in order",
+			items: []tasks.OrderItem{{ProductID: 20, Quantity: dec("1")}, {ProductID: 1, Quantity: dec("1")}},
+			want: []Line{
+				{ProductID: 20, Quantity: dec("1")},
+				{ProductID: 10, Quantity: dec("2"), BundleProductID: 1},
+				{ProductID: 20, Quantity: dec("0.5"), BundleProductID: 1},
+			},
+		},
+		{
+			name:  "the entered unit is kept for the ledger",
+			items: []tasks.OrderItem{{ProductID: 10, Quantity: dec("24"), Unit: "BOX"}},
+			units: units,
+			want:  []Line{{ProductID: 10, Quantity: dec("24"), Unit: "BOX", UnitFactor: dec("12")}},
+		},
+		{
+			name:  "the base unit is recorded with factor 1",
+			items: []tasks.OrderItem{{ProductID: 10, Quantity: dec("2"), Unit: "PCS"}},
+			units: units,
+			want:  []Line{{ProductID: 10, Quantity: dec("2"), Unit: "PCS", UnitFactor: dec("1")}},
+		},
+		{
+			name:  "without units",
+			items: []tasks.OrderItem{{ProductID: 10, Quantity: dec("2")}},
+			want:  []Line{{ProductID: 10, Quantity: dec("2")}},
+		},
+		{
+			name: "no items",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Expand(tt.items, components, tt.units)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expand = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	components := map[int64][]Component{
+		1: {{ProductID: 10, Quantity: dec("2")}, {ProductID: 20, Quantity: dec("0.5")}},
+		2: {},
+	}
+	tests := []struct {
+		name      string
+		productID int64
+		available map[int64]decimal.Decimal
+		want      string
+	}{
+		{"simple product", 10, map[int64]decimal.Decimal{10: dec("7.5")}, "7.5"},
+		{"simple product without stock", 10, nil, "0"},
+		{"scarcest component limits the bundle", 1, map[int64]decimal.Decimal{10: dec("9"), 20: dec("10")}, "4"},
+		{"fractional components", 1, map[int64]decimal.Decimal{10: dec("100"), 20: dec("1.4")}, "2"},
+		{"only whole sets count", 1, map[int64]decimal.Decimal{10: dec("1.99"), 20: dec("10")}, "0"},
+		{"a missing component", 1, map[int64]decimal.Decimal{10: dec("10")}, "0"},
+		{"an empty bill of materials", 2, nil, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Available(tt.productID, components, tt.available); !got.Equal(dec(tt.want)) {
+				t.Errorf("Available = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
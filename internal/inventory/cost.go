@@ -0,0 +1,202 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/numeric"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Costing methods stored in costing_method.method.
+const (
+	// CostFIFO issues stock at the cost of the oldest receipts still on hand.
+	CostFIFO = "FIFO"
+	// CostAverage issues stock at the moving average cost of the stock row.
+	CostAverage = "AVERAGE"
+)
+
+// DefaultCostingMethod is used for tenants without a costing_method row.
+const DefaultCostingMethod = CostFIFO
+
+// Cost is the cost of a ledger row. Total is signed like quantity_change.
+type Cost struct {
+	UnitCost decimal.Decimal `json:"unit_cost"`
+	Total    decimal.Decimal `json:"total_cost"`
+}
+
+// LoadCostingMethod returns the tenant's costing method.
+func LoadCostingMethod(ctx context.Context, q Querier, tenantID int64) (string, error) {
+	var method string
+	err := q.QueryRow(ctx, `SELECT method FROM costing_method WHERE tenant_id = $1`, tenantID).Scan(&method)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultCostingMethod, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load costing method: %w", err)
+	}
+	return method, nil
+}
+
+// ReceiveCost records quantity units received into stockID, which held
+// before units until now. Without a unitCost the units come in at the
+// current average cost. Every receipt adds a FIFO layer whatever the method,
+// so a tenant can switch methods later.
+func ReceiveCost(ctx context.Context, tx pgx.Tx, tenantID, stockID int64, before, quantity decimal.Decimal, unitCost *decimal.Decimal) (Cost, error) {
+	var value decimal.Decimal
+	if err := tx.QueryRow(ctx, `SELECT value FROM stock WHERE id = $1 FOR UPDATE`, stockID).Scan(&value); err != nil {
+		return Cost{}, fmt.Errorf("failed to load stock value: %w", err)
+	}
+
+	cost := Cost{}
+	switch {
+	case unitCost != nil:
+		cost.UnitCost = *unitCost
+	case before.IsPositive():
+		cost.UnitCost = value.Div(before).Round(numeric.MoneyScale)
+	}
+	cost.Total = quantity.Mul(cost.UnitCost).Round(numeric.MoneyScale)
+
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO cost_layer (tenant_id, stock_id, quantity, remaining, unit_cost) VALUES ($1, $2, $3, $3, $4)`,
+		tenantID, stockID, quantity, cost.UnitCost,
+	)
+	if err != nil {
+		return Cost{}, fmt.Errorf("failed to insert cost layer: %w", err)
+	}
+	if err := addStockValue(ctx, tx, stockID, cost.Total); err != nil {
+		return Cost{}, err
+	}
+	return cost, nil
+}
+
+// IssueCost records quantity units leaving stockID, which held before units
+// until now, and returns their cost as a negative Total. FIFO layers are
+// consumed under both methods; AVERAGE only ignores their cost.
+func IssueCost(ctx context.Context, tx pgx.Tx, tenantID, stockID int64, before, quantity decimal.Decimal) (Cost, error) {
+	method, err := LoadCostingMethod(ctx, tx, tenantID)
+	if err != nil {
+		return Cost{}, err
+	}
+	var value decimal.Decimal
+	if err := tx.QueryRow(ctx, `SELECT value FROM stock WHERE id = $1 FOR UPDATE`, stockID).Scan(&value); err != nil {
+		return Cost{}, fmt.Errorf("failed to load stock value: %w", err)
+	}
+
+	fifo, err := consumeLayers(ctx, tx, stockID, quantity)
+	if err != nil {
+		return Cost{}, err
+	}
+
+	total := fifo
+	if method == CostAverage {
+		total = value
+		if before.GreaterThan(quantity) {
+			total = value.Mul(quantity).Div(before).Round(numeric.MoneyScale)
+		}
+	}
+
+	cost := Cost{Total: total.Neg()}
+	if quantity.IsPositive() {
+		cost.UnitCost = total.Div(quantity).Round(numeric.MoneyScale)
+	}
+	if err := addStockValue(ctx, tx, stockID, cost.Total); err != nil {
+		return Cost{}, err
+	}
+	return cost, nil
+}
+
+// consumeLayers takes quantity out of the oldest layers of stockID and
+// returns their cost. Units not covered by layers cost nothing.
+func consumeLayers(ctx context.Context, tx pgx.Tx, stockID int64, quantity decimal.Decimal) (decimal.Decimal, error) {
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, remaining, unit_cost FROM cost_layer WHERE stock_id = $1 AND remaining > 0 ORDER BY id FOR UPDATE`,
+		stockID,
+	)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load cost layers: %w", err)
+	}
+
+	type layer struct {
+		id        int64
+		remaining decimal.Decimal
+		unitCost  decimal.Decimal
+	}
+	var layers []layer
+	for rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.id, &l.remaining, &l.unitCost); err != nil {
+			rows.Close()
+			return decimal.Zero, fmt.Errorf("failed to scan cost layer: %w", err)
+		}
+		layers = append(layers, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load cost layers: %w", err)
+	}
+
+	total := decimal.Zero
+	remaining := quantity
+	for _, l := range layers {
+		if !remaining.IsPositive() {
+			break
+		}
+		take := decimal.Min(l.remaining, remaining)
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE cost_layer SET remaining = remaining - $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+			take, l.id,
+		)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("failed to update cost layer: %w", err)
+		}
+		total = total.Add(take.Mul(l.unitCost))
+		remaining = remaining.Sub(take)
+	}
+	return total.Round(numeric.MoneyScale), nil
+}
+
+func addStockValue(ctx context.Context, tx pgx.Tx, stockID int64, change decimal.Decimal) error {
+	_, err := tx.Exec(ctx, `UPDATE stock SET value = value + $1 WHERE id = $2`, change, stockID)
+	if err != nil {
+		return fmt.Errorf("failed to update stock value: %w", err)
+	}
+	return nil
+}
+
+// ResetLayers replaces the layers of every stock row of the tenant by one
+// layer at the row's average cost. Switching to FIFO starts from the value
+// on hand instead of layers the average method did not price.
+func ResetLayers(ctx context.Context, tx pgx.Tx, tenantID int64) error {
+	_, err := tx.Exec(ctx, `DELETE FROM cost_layer WHERE tenant_id = $1 AND remaining > 0`, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to reset cost layers: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO cost_layer (tenant_id, stock_id, quantity, remaining, unit_cost)
+		SELECT tenant_id, id, quantity, quantity, ROUND(value / quantity, 4)
+		FROM stock WHERE tenant_id = $1 AND quantity > 0`,
+		tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reset cost layers: %w", err)
+	}
+	// rounding the unit cost may move the value by a fraction of a cent
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE stock SET value = CASE WHEN quantity > 0 THEN ROUND(quantity * ROUND(value / quantity, 4), 4) ELSE 0 END
+		WHERE tenant_id = $1`,
+		tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reset stock value: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/correlation"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Stock count statuses stored in stock_count.status.
+const (
+	CountOpen      = "OPEN"
+	CountPosted    = "POSTED"
+	CountCancelled = "CANCELLED"
+)
+
+// Adjustment reasons stored in stock_count_line.reason and transaction.reason.
+const (
+	ReasonDamage    = "DAMAGE"
+	ReasonShrinkage = "SHRINKAGE"
+	// ReasonFound is the only reason for a count above the expected quantity.
+	ReasonFound = "FOUND"
+)
+
+var (
+	ErrCountNotFound = errors.New("stock count not found")
+	ErrCountNotOpen  = errors.New("stock count is already posted or cancelled")
+)
+
+// StockFrozenError is returned when stock changes while a count freezes it.
+type StockFrozenError struct {
+	ProductID int64
+	CountID   int64
+}
+
+func (e *StockFrozenError) Error() string {
+	return fmt.Sprintf("product_id=%d is frozen by stock count %d", e.ProductID, e.CountID)
+}
+
+// CountLineError is returned when a count line cannot be submitted or posted.
+type CountLineError struct {
+	ProductID int64
+	Reason    string
+}
+
+func (e *CountLineError) Error() string {
+	if e.ProductID == 0 {
+		return "stock count: " + e.Reason
+	}
+	return fmt.Sprintf("count of product_id=%d: %s", e.ProductID, e.Reason)
+}
+
+// ValidReason reports whether reason explains a variance of the given sign.
+func ValidReason(reason string, variance decimal.Decimal) bool {
+	if variance.IsPositive() {
+		return reason == ReasonFound
+	}
+	return reason == ReasonDamage || reason == ReasonShrinkage
+}
+
+// CheckFrozen returns a StockFrozenError when a count freezes stockID.
+func CheckFrozen(ctx context.Context, tx pgx.Tx, stockID, productID int64) error {
+	var countID *int64
+	err := tx.QueryRow(ctx, `SELECT frozen_count_id FROM stock WHERE id = $1`, stockID).Scan(&countID)
+	if err != nil {
+		return fmt.Errorf("failed to load stock: %w", err)
+	}
+	if countID != nil {
+		return &StockFrozenError{ProductID: productID, CountID: *countID}
+	}
+	return nil
+}
+
+// OpenCount starts a count of warehouseID over the given products, or every
+// stock row of the warehouse when productIDs is empty. The expected quantity
+// of each line is the stock quantity now; with freeze the rows take no other
+// changes until the count is posted or cancelled.
+func OpenCount(ctx context.Context, tx pgx.Tx, tenantID, warehouseID int64, productIDs []int64, freeze bool) (int64, error) {
+	var countID int64
+	err := tx.QueryRow(
+		ctx,
+		`INSERT INTO stock_count (tenant_id, warehouse_id, status, freeze) VALUES ($1, $2, $3, $4) RETURNING id`,
+		tenantID, warehouseID, CountOpen, freeze,
+	).Scan(&countID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stock count: %w", err)
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		`INSERT INTO stock_count_line (stock_count_id, stock_id, product_id, expected)
+		SELECT $1, id, product_id, quantity FROM stock
+		WHERE tenant_id = $2 AND warehouse_id = $3 AND (cardinality($4::bigint[]) = 0 OR product_id = ANY($4))
+		ORDER BY product_id`,
+		countID, tenantID, warehouseID, productIDs,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stock count lines: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, &CountLineError{Reason: "no stock to count in the warehouse"}
+	}
+	if len(productIDs) > 0 && tag.RowsAffected() != int64(len(productIDs)) {
+		return 0, &CountLineError{Reason: "every product must have stock in the warehouse"}
+	}
+
+	if freeze {
+		tag, err := tx.Exec(
+			ctx,
+			`UPDATE stock SET frozen_count_id = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+			WHERE id IN (SELECT stock_id FROM stock_count_line WHERE stock_count_id = $1) AND frozen_count_id IS NULL`,
+			countID,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to freeze stock: %w", err)
+		}
+		var lines int64
+		err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM stock_count_line WHERE stock_count_id = $1`, countID).Scan(&lines)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load stock count lines: %w", err)
+		}
+		if tag.RowsAffected() != lines {
+			return 0, &CountLineError{Reason: "some products are already frozen by another count"}
+		}
+	}
+	return countID, nil
+}
+
+// lockCount loads an open count for update.
+func lockCount(ctx context.Context, tx pgx.Tx, tenantID, countID int64) (warehouseID int64, err error) {
+	var status string
+	err = tx.QueryRow(
+		ctx,
+		`SELECT warehouse_id, status FROM stock_count WHERE id = $1 AND tenant_id = $2 FOR UPDATE`,
+		countID, tenantID,
+	).Scan(&warehouseID, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrCountNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load stock count: %w", err)
+	}
+	if status != CountOpen {
+		return 0, ErrCountNotOpen
+	}
+	return warehouseID, nil
+}
+
+// CountedLine is a counted quantity submitted for a product of a count.
+type CountedLine struct {
+	ProductID int64
+	Counted   decimal.Decimal
+	Reason    string
+}
+
+// SubmitCount records counted quantities on an open count. A line may be
+// submitted again until the count is posted.
+func SubmitCount(ctx context.Context, tx pgx.Tx, tenantID, countID int64, lines []CountedLine) error {
+	if _, err := lockCount(ctx, tx, tenantID, countID); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		var reason *string
+		if line.Reason != "" {
+			reason = &line.Reason
+		}
+		var expected decimal.Decimal
+		err := tx.QueryRow(
+			ctx,
+			`UPDATE stock_count_line SET counted = $1, reason = $2, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+			WHERE stock_count_id = $3 AND product_id = $4
+			RETURNING expected`,
+			line.Counted, reason, countID, line.ProductID,
+		).Scan(&expected)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &CountLineError{ProductID: line.ProductID, Reason: "product is not in the count"}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update stock count line: %w", err)
+		}
+		if !line.Counted.Equal(expected) {
+			if err := checkCountable(ctx, tx, line.ProductID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PostCount applies the variance of every counted line, counted minus
+// expected, to current stock and writes one STOCK_COUNT/ADJUST ledger row per
+// line with its reason and approvedBy. Each variance needs a matching reason;
+// lines left uncounted are not adjusted. It returns the number of rows
+// adjusted.
+func PostCount(ctx context.Context, tx pgx.Tx, tenantID, countID int64, approvedBy string) (int, error) {
+	warehouseID, err := lockCount(ctx, tx, tenantID, countID)
+	if err != nil {
+		return 0, err
+	}
+
+	type countLine struct {
+		stockID   int64
+		productID int64
+		variance  decimal.Decimal
+		reason    string
+	}
+	rows, err := tx.Query(
+		ctx,
+		`SELECT stock_id, product_id, counted - expected, COALESCE(reason, '')
+		FROM stock_count_line
+		WHERE stock_count_id = $1 AND counted IS NOT NULL AND counted <> expected
+		ORDER BY id`,
+		countID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load stock count lines: %w", err)
+	}
+	var lines []countLine
+	for rows.Next() {
+		var l countLine
+		if err := rows.Scan(&l.stockID, &l.productID, &l.variance, &l.reason); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan stock count line: %w", err)
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to load stock count lines: %w", err)
+	}
+
+	for _, l := range lines {
+		if !ValidReason(l.reason, l.variance) {
+			return 0, &CountLineError{ProductID: l.productID, Reason: "reason must be FOUND for a surplus and DAMAGE or SHRINKAGE for a loss"}
+		}
+		if err := checkCountable(ctx, tx, l.productID); err != nil {
+			return 0, err
+		}
+	}
+
+	// the count's own freeze must not block its adjustments
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE stock SET frozen_count_id = NULL WHERE frozen_count_id = $1`,
+		countID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unfreeze stock: %w", err)
+	}
+
+	for _, l := range lines {
+		if err := adjustStock(ctx, tx, tenantID, warehouseID, countID, l.stockID, l.productID, l.variance, l.reason, approvedBy); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE stock_count SET status = $1, approved_by = $2, posted_date = CURRENT_TIMESTAMP, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE id = $3`,
+		CountPosted, approvedBy, countID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post stock count: %w", err)
+	}
+	return len(lines), nil
+}
+
+// CancelCount drops an open count and lifts its freeze.
+func CancelCount(ctx context.Context, tx pgx.Tx, tenantID, countID int64) error {
+	if _, err := lockCount(ctx, tx, tenantID, countID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `UPDATE stock SET frozen_count_id = NULL WHERE frozen_count_id = $1`, countID)
+	if err != nil {
+		return fmt.Errorf("failed to unfreeze stock: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE stock_count SET status = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+		CountCancelled, countID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel stock count: %w", err)
+	}
+	return nil
+}
+
+// checkCountable rejects a variance on a serialized product: a count carries
+// no serial numbers, so it cannot tell which units were found or are missing.
+func checkCountable(ctx context.Context, tx pgx.Tx, productID int64) error {
+	serialized, err := isSerialized(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+	if serialized {
+		return &CountLineError{ProductID: productID, Reason: "serialized products are adjusted by serial number, not by count"}
+	}
+	return nil
+}
+
+// adjustStock moves stockID by variance. Losses leave bins and lots like an
+// order would; surpluses come in unlocated at the average cost and fill
+// waiting backorders like a receipt.
+func adjustStock(ctx context.Context, tx pgx.Tx, tenantID, warehouseID, countID, stockID, productID int64, variance decimal.Decimal, reason, approvedBy string) error {
+	var before, reserve decimal.Decimal
+	err := tx.QueryRow(ctx, `SELECT quantity, reserve FROM stock WHERE id = $1 FOR UPDATE`, stockID).Scan(&before, &reserve)
+	if err != nil {
+		return fmt.Errorf("failed to load stock: %w", err)
+	}
+
+	var lots *string
+	var cost Cost
+	if variance.IsNegative() {
+		loss := variance.Neg()
+		if before.LessThan(loss) {
+			return &InsufficientStockError{ProductID: productID, Stock: before, Required: loss}
+		}
+		if err := PickLocations(ctx, tx, stockID, loss); err != nil {
+			return err
+		}
+		picks, err := PickLotsForLoss(ctx, tx, stockID, loss)
+		if err != nil {
+			return err
+		}
+		if lots, err = LotsJSON(picks); err != nil {
+			return err
+		}
+		if cost, err = IssueCost(ctx, tx, tenantID, stockID, before, loss); err != nil {
+			return err
+		}
+	} else {
+		if cost, err = ReceiveCost(ctx, tx, tenantID, stockID, before, variance, nil); err != nil {
+			return err
+		}
+	}
+
+	after := before.Add(variance)
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE stock SET quantity = $1, on_hand = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+		after, stockID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update stock: %w", err)
+	}
+	if err := CheckLowStock(ctx, tx, stockID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO transaction (
+			model, event, teanant_id, product_id, warehouse_id, stock_id,
+			quantity_old, quantity_change, quantity_new,
+			reserve_old, reserve_change, reserve_new,
+			on_hand_old, on_hand_change, on_hand_new,
+			status, lots, unit_cost, total_cost, reason, approved_by, stock_count_id, request_id,
+			create_date, update_date, row_create_date, row_update_date
+		) VALUES (
+			$1, $2, $3, $4, $5, $6,
+			$7, $8, $9,
+			$10, $11, $12,
+			$13, $14, $15,
+			$16, $17, $18, $19, $20, $21, $22, NULLIF($23, ''),
+			CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		)`,
+		"STOCK_COUNT", "ADJUST", tenantID, productID, warehouseID, stockID,
+		before, variance, after,
+		reserve, decimal.Zero, reserve,
+		before, variance, after,
+		true, lots, cost.UnitCost, cost.Total, reason, approvedBy, countID, correlation.ID(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert transaction: %w", err)
+	}
+
+	if variance.IsPositive() {
+		if _, err := FillBackorders(ctx, tx, tenantID, warehouseID, productID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package inventory
+
+import "testing"
+
+func TestValidReason(t *testing.T) {
+	tests := []struct {
+		reason   string
+		variance string
+		want     bool
+	}{
+		{ReasonFound, "2", true},
+		{ReasonDamage, "2", false},
+		{ReasonShrinkage, "0.5", false},
+		{ReasonDamage, "-1", true},
+		{ReasonShrinkage, "-0.25", true},
+		{ReasonFound, "-1", false},
+		{"", "-1", false},
+		{"damage", "-1", false},
+		{"THEFT", "-1", false},
+	}
+	for _, tt := range tests {
+		if got := ValidReason(tt.reason, dec(tt.variance)); got != tt.want {
+			t.Errorf("ValidReason(%q, %s) = %v, want %v", tt.reason, tt.variance, got, tt.want)
+		}
+	}
+}
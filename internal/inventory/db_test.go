@@ -0,0 +1,87 @@
+package inventory
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgtype"
+	shopspring "github.com/jackc/pgtype/ext/shopspring-numeric"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// testTx opens a transaction on the database named by
+// ATLASQ_TEST_DATABASE_URL, which must hold a migrated atlasq schema. It is
+// rolled back when the test ends, so tests may write freely.
+func testTx(t *testing.T) pgx.Tx {
+	t.Helper()
+	url := os.Getenv("ATLASQ_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("ATLASQ_TEST_DATABASE_URL is not set")
+	}
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		conn.ConnInfo().RegisterDataType(pgtype.DataType{
+			Value: &shopspring.Numeric{},
+			Name:  "numeric",
+			OID:   pgtype.NumericOID,
+		})
+		return nil
+	}
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	tx, err := pool.Begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tx.Rollback(context.Background()) })
+	return tx
+}
+
+// testTenantID keeps test rows apart from anything already in the database.
+const testTenantID = -1
+
+func insertProduct(t *testing.T, tx pgx.Tx, sku string, serialized bool) int64 {
+	t.Helper()
+	var id int64
+	err := tx.QueryRow(
+		context.Background(),
+		`INSERT INTO product (tenant_id, name, description, price, sku, serialized) VALUES ($1, $2, '', 0, $2, $3) RETURNING id`,
+		testTenantID, sku, serialized,
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func insertStock(t *testing.T, tx pgx.Tx, warehouseID, productID int64, quantity decimal.Decimal) int64 {
+	t.Helper()
+	var id int64
+	err := tx.QueryRow(
+		context.Background(),
+		`INSERT INTO stock (
+			tenant_id, warehouse_id, product_id,
+			minimum, quantity, reserve, on_hand, status, source,
+			create_date, update_date, row_create_date, row_update_date
+		) VALUES (
+			$1, $2, $3,
+			0, $4, 0, $4, true, 'RECEIPT',
+			CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		) RETURNING id`,
+		testTenantID, warehouseID, productID, quantity,
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
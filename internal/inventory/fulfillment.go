@@ -0,0 +1,177 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+
+	tasks "atlasq/internal/tasks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Fulfillment modes accepted in fulfillment_mode of an order.
+const (
+	// FulfillAllOrNothing rejects the whole order when any line is short.
+	FulfillAllOrNothing = "ALL_OR_NOTHING"
+	// FulfillAvailable ships what is in stock and drops the shortfall.
+	FulfillAvailable = "FILL_AVAILABLE"
+	// FulfillBackorder ships what is in stock and backorders the shortfall.
+	FulfillBackorder = "BACKORDER"
+)
+
+// Shortage is the part of an order line that could not be shipped.
+type Shortage struct {
+	ProductID   int64           `json:"product_id"`
+	Required    decimal.Decimal `json:"required"`
+	Shipped     decimal.Decimal `json:"shipped"`
+	Short       decimal.Decimal `json:"short"`
+	BackorderID int64           `json:"backorder_id,omitempty"`
+}
+
+// OrderResult describes what FulfillOrder did with an order.
+type OrderResult struct {
+	// Allocation is nil when the order named its warehouse.
+	Allocation *Allocation `json:"allocation"`
+	Shortages  []Shortage  `json:"shortages"`
+	// Deducted is the stock taken over all lines, in base units.
+	Deducted decimal.Decimal `json:"-"`
+}
+
+// FulfillOrder deducts the order items after checking that every product
+// belongs to the tenant. With a warehouseID the whole order ships from that
+// warehouse; with warehouseID 0 the order is allocated over the tenant's
+// warehouses first and the allocation is recorded.
+//
+// Quantities are converted to each product's base unit first, so shortages
+// and backorders are in base units. Bundles are expanded into their
+// components, which are deducted in the same transaction. In FILL_AVAILABLE
+// and BACKORDER mode lines are cut down to the available stock before
+// deducting, and BACKORDER persists the shortfall so a later receipt can fill
+// it (see FillBackorders).
+func FulfillOrder(ctx context.Context, tx pgx.Tx, tenantID, warehouseID int64, items []tasks.OrderItem, mode string) (*OrderResult, error) {
+	result := &OrderResult{Shortages: []Shortage{}}
+
+	if err := CheckProducts(ctx, tx, tenantID, items); err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]int64, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	components, err := LoadComponents(ctx, tx, tenantID, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	units, err := LoadUnits(ctx, tx, tenantID, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	if items, err = ToBase(items, units); err != nil {
+		return nil, err
+	}
+	if err := CheckOrderSerials(ctx, tx, items); err != nil {
+		return nil, err
+	}
+
+	if mode == FulfillAvailable || mode == FulfillBackorder {
+		available, err := availableStock(ctx, tx, tenantID, warehouseID, stockIDs(productIDs, components))
+		if err != nil {
+			return nil, err
+		}
+		// bundles are cut down to whole sets, never to loose components
+		var shippable []tasks.OrderItem
+		for _, item := range items {
+			take := decimal.Min(item.Quantity, Available(item.ProductID, components, available))
+			if take.IsPositive() {
+				shippable = append(shippable, tasks.OrderItem{ProductID: item.ProductID, Quantity: take, Unit: item.Unit, Serials: item.Serials})
+				consume(item.ProductID, take, components, available)
+			} else {
+				take = decimal.Zero
+			}
+			if take.LessThan(item.Quantity) {
+				result.Shortages = append(result.Shortages, Shortage{
+					ProductID: item.ProductID,
+					Required:  item.Quantity,
+					Shipped:   take,
+					Short:     item.Quantity.Sub(take),
+				})
+			}
+		}
+		items = shippable
+	}
+
+	lines := Expand(items, components, units)
+	for _, line := range lines {
+		result.Deducted = result.Deducted.Add(line.Quantity)
+	}
+	if len(lines) > 0 {
+		if warehouseID != 0 {
+			if err := DeductLines(ctx, tx, tenantID, warehouseID, lines); err != nil {
+				return nil, err
+			}
+		} else {
+			alloc, err := Allocate(ctx, tx, tenantID, lines)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range alloc.Fulfillments {
+				if err := DeductLines(ctx, tx, tenantID, f.WarehouseID, f.Items); err != nil {
+					return nil, err
+				}
+			}
+			result.Allocation = alloc
+		}
+	}
+
+	if mode == FulfillBackorder {
+		var allocationID *int64
+		if result.Allocation != nil {
+			allocationID = &result.Allocation.ID
+		}
+		for i := range result.Shortages {
+			id, err := createBackorder(ctx, tx, tenantID, warehouseID, allocationID, result.Shortages[i])
+			if err != nil {
+				return nil, err
+			}
+			result.Shortages[i].BackorderID = id
+		}
+	}
+
+	return result, nil
+}
+
+// availableStock locks and returns the stock per product, either in one
+// warehouse or summed over all warehouses when warehouseID is 0. Stock
+// frozen by a count or in expired lots is not available.
+func availableStock(ctx context.Context, tx pgx.Tx, tenantID, warehouseID int64, productIDs []int64) (map[int64]decimal.Decimal, error) {
+	rows, err := tx.Query(
+		ctx,
+		`SELECT product_id, `+usableQuantity+` FROM stock
+		WHERE tenant_id = $1 AND product_id = ANY($2) AND ($3 = 0 OR warehouse_id = $3) AND status = true
+			AND frozen_count_id IS NULL
+		FOR UPDATE`,
+		tenantID, productIDs, warehouseID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load available stock: %w", err)
+	}
+	defer rows.Close()
+
+	available := map[int64]decimal.Decimal{}
+	for rows.Next() {
+		var productID int64
+		var quantity decimal.Decimal
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			return nil, fmt.Errorf("failed to scan available stock: %w", err)
+		}
+		if quantity.IsPositive() {
+			available[productID] = available[productID].Add(quantity)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load available stock: %w", err)
+	}
+	return available, nil
+}
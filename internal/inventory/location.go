@@ -0,0 +1,162 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Location types, from the widest to the narrowest. Only bins hold stock.
+const (
+	LocationZone  = "ZONE"
+	LocationAisle = "AISLE"
+	LocationBin   = "BIN"
+)
+
+// Pick strategies stored in stock.pick_strategy.
+const (
+	// PickLargestFirst empties the bin holding the most units first.
+	PickLargestFirst = "LARGEST_FIRST"
+	// PickFixedBin picks from stock.fixed_location_id first and falls back to
+	// largest-first once the fixed bin is empty.
+	PickFixedBin = "FIXED_BIN"
+)
+
+var (
+	ErrNotEnoughUnlocated = errors.New("not enough stock outside of bins")
+	ErrNotEnoughInBin     = errors.New("not enough stock in source bin")
+)
+
+// Unlocated returns the part of stock.quantity that has not been put away
+// into a bin yet.
+func Unlocated(ctx context.Context, tx pgx.Tx, stockID int64) (decimal.Decimal, error) {
+	var unlocated decimal.Decimal
+	err := tx.QueryRow(
+		ctx,
+		`SELECT s.quantity - COALESCE((SELECT SUM(sl.quantity) FROM stock_location sl WHERE sl.stock_id = s.id), 0)
+		FROM stock s WHERE s.id = $1`,
+		stockID,
+	).Scan(&unlocated)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load unlocated stock: %w", err)
+	}
+	return unlocated, nil
+}
+
+// PickLocations takes quantity out of the bins holding stockID according to
+// the stock's pick strategy. Whatever the bins cannot cover comes from the
+// unlocated remainder, so the caller must check stock.quantity beforehand and
+// still updates the warehouse-level row itself.
+func PickLocations(ctx context.Context, tx pgx.Tx, stockID int64, quantity decimal.Decimal) error {
+	var strategy string
+	var fixedLocationID *int64
+	err := tx.QueryRow(
+		ctx,
+		`SELECT pick_strategy, fixed_location_id FROM stock WHERE id = $1`,
+		stockID,
+	).Scan(&strategy, &fixedLocationID)
+	if err != nil {
+		return fmt.Errorf("failed to load pick strategy: %w", err)
+	}
+	if strategy != PickFixedBin {
+		fixedLocationID = nil
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, quantity FROM stock_location
+		WHERE stock_id = $1 AND quantity > 0
+		ORDER BY COALESCE(location_id = $2, false) DESC, quantity DESC, id
+		FOR UPDATE`,
+		stockID, fixedLocationID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load stock locations: %w", err)
+	}
+
+	type bin struct {
+		id       int64
+		quantity decimal.Decimal
+	}
+	var bins []bin
+	for rows.Next() {
+		var b bin
+		if err := rows.Scan(&b.id, &b.quantity); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan stock location: %w", err)
+		}
+		bins = append(bins, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load stock locations: %w", err)
+	}
+
+	remaining := quantity
+	for _, b := range bins {
+		if !remaining.IsPositive() {
+			break
+		}
+		take := decimal.Min(b.quantity, remaining)
+		_, err := tx.Exec(
+			ctx,
+			`UPDATE stock_location SET quantity = quantity - $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+			take, b.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update stock location: %w", err)
+		}
+		remaining = remaining.Sub(take)
+	}
+	return nil
+}
+
+// Putaway moves quantity from the unlocated remainder of stockID into a bin.
+func Putaway(ctx context.Context, tx pgx.Tx, tenantID, stockID, locationID int64, quantity decimal.Decimal) error {
+	unlocated, err := Unlocated(ctx, tx, stockID)
+	if err != nil {
+		return err
+	}
+	if unlocated.LessThan(quantity) {
+		return ErrNotEnoughUnlocated
+	}
+	return addToLocation(ctx, tx, tenantID, stockID, locationID, quantity)
+}
+
+// Move transfers quantity of stockID between two bins. The warehouse total
+// does not change.
+func Move(ctx context.Context, tx pgx.Tx, tenantID, stockID, fromLocationID, toLocationID int64, quantity decimal.Decimal) error {
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE stock_location SET quantity = quantity - $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE stock_id = $2 AND location_id = $3 AND quantity >= $1`,
+		quantity, stockID, fromLocationID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update source location: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotEnoughInBin
+	}
+	return addToLocation(ctx, tx, tenantID, stockID, toLocationID, quantity)
+}
+
+func addToLocation(ctx context.Context, tx pgx.Tx, tenantID, stockID, locationID int64, quantity decimal.Decimal) error {
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO stock_location (tenant_id, stock_id, location_id, quantity)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (stock_id, location_id) DO UPDATE SET
+			quantity = stock_location.quantity + EXCLUDED.quantity,
+			update_date = CURRENT_TIMESTAMP,
+			row_update_date = CURRENT_TIMESTAMP`,
+		tenantID, stockID, locationID, quantity,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update target location: %w", err)
+	}
+	return nil
+}
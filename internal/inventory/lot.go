@@ -0,0 +1,206 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// ExpiryWarningDays is how far ahead the daily expiry job flags lots.
+const ExpiryWarningDays = 30
+
+var (
+	ErrLotNotFound       = errors.New("lot not found")
+	ErrNotEnoughInLot    = errors.New("not enough stock in lot")
+	ErrLotExpiryMismatch = errors.New("lot already exists with a different expiry_date")
+	ErrLotsExpired       = errors.New("not enough stock outside of expired lots, name the lot to take expired stock")
+)
+
+// usableQuantity is the SQL expression for the stock quantity outside of
+// expired lots, for queries selecting from stock. Orders never pick expired
+// lots, so only this much is available to them.
+const usableQuantity = `stock.quantity - COALESCE((SELECT SUM(stock_lot.quantity) FROM stock_lot WHERE stock_lot.stock_id = stock.id AND stock_lot.expiry_date < CURRENT_DATE), 0)`
+
+// LotPick is the part of a ledger row received into or taken from one lot.
+type LotPick struct {
+	LotID      int64           `json:"lot_id"`
+	LotNumber  string          `json:"lot_number"`
+	ExpiryDate *time.Time      `json:"expiry_date"`
+	Quantity   decimal.Decimal `json:"quantity"`
+}
+
+// ReceiveLot adds quantity to the lot of stockID, creating the lot on its
+// first receipt. A later receipt may omit expiryDate but not change it.
+func ReceiveLot(ctx context.Context, tx pgx.Tx, tenantID, stockID int64, lotNumber string, expiryDate *time.Time, quantity decimal.Decimal) (*LotPick, error) {
+	pick := &LotPick{LotNumber: lotNumber, Quantity: quantity}
+	err := tx.QueryRow(
+		ctx,
+		`INSERT INTO stock_lot (tenant_id, stock_id, lot_number, expiry_date, quantity)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (stock_id, lot_number) DO UPDATE SET
+			quantity = stock_lot.quantity + EXCLUDED.quantity,
+			update_date = CURRENT_TIMESTAMP,
+			row_update_date = CURRENT_TIMESTAMP
+		WHERE EXCLUDED.expiry_date IS NULL OR stock_lot.expiry_date IS NOT DISTINCT FROM EXCLUDED.expiry_date
+		RETURNING id, expiry_date`,
+		tenantID, stockID, lotNumber, expiryDate, quantity,
+	).Scan(&pick.LotID, &pick.ExpiryDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrLotExpiryMismatch
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update lot: %w", err)
+	}
+	return pick, nil
+}
+
+// TakeLot takes quantity out of one named lot of stockID.
+func TakeLot(ctx context.Context, tx pgx.Tx, stockID int64, lotNumber string, quantity decimal.Decimal) (*LotPick, error) {
+	pick := &LotPick{LotNumber: lotNumber, Quantity: quantity}
+	var available decimal.Decimal
+	err := tx.QueryRow(
+		ctx,
+		`SELECT id, expiry_date, quantity FROM stock_lot WHERE stock_id = $1 AND lot_number = $2 FOR UPDATE`,
+		stockID, lotNumber,
+	).Scan(&pick.LotID, &pick.ExpiryDate, &available)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrLotNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lot: %w", err)
+	}
+	if available.LessThan(quantity) {
+		return nil, ErrNotEnoughInLot
+	}
+	if err := takeFromLot(ctx, tx, pick.LotID, quantity); err != nil {
+		return nil, err
+	}
+	return pick, nil
+}
+
+// PickLots takes quantity out of the lots of stockID, first-expiring first
+// out; lots without an expiry date go last and expired lots are never
+// picked. Whatever the lots cannot cover comes from untracked stock, so like
+// PickLocations the caller must check stock.quantity beforehand. If only
+// expired stock is left to cover it, ErrLotsExpired is returned.
+func PickLots(ctx context.Context, tx pgx.Tx, stockID int64, quantity decimal.Decimal) ([]LotPick, error) {
+	return pickLots(ctx, tx, stockID, quantity, false)
+}
+
+// PickLotsForLoss is PickLots for stock that is gone, such as a count
+// variance, where expired lots are the likeliest to have been thrown away
+// and go first.
+func PickLotsForLoss(ctx context.Context, tx pgx.Tx, stockID int64, quantity decimal.Decimal) ([]LotPick, error) {
+	return pickLots(ctx, tx, stockID, quantity, true)
+}
+
+func pickLots(ctx context.Context, tx pgx.Tx, stockID int64, quantity decimal.Decimal, withExpired bool) ([]LotPick, error) {
+	var stockQty decimal.Decimal
+	if err := tx.QueryRow(ctx, `SELECT quantity FROM stock WHERE id = $1`, stockID).Scan(&stockQty); err != nil {
+		return nil, fmt.Errorf("failed to load stock: %w", err)
+	}
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, lot_number, expiry_date, quantity, COALESCE(expiry_date < CURRENT_DATE, false) FROM stock_lot
+		WHERE stock_id = $1 AND quantity > 0
+		ORDER BY expiry_date NULLS LAST, id
+		FOR UPDATE`,
+		stockID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lots: %w", err)
+	}
+
+	var lots []LotPick
+	untracked := stockQty
+	for rows.Next() {
+		var l LotPick
+		var expired bool
+		if err := rows.Scan(&l.LotID, &l.LotNumber, &l.ExpiryDate, &l.Quantity, &expired); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan lot: %w", err)
+		}
+		untracked = untracked.Sub(l.Quantity)
+		if expired && !withExpired {
+			continue
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load lots: %w", err)
+	}
+
+	var picks []LotPick
+	remaining := quantity
+	for _, l := range lots {
+		if !remaining.IsPositive() {
+			break
+		}
+		take := decimal.Min(l.Quantity, remaining)
+		if err := takeFromLot(ctx, tx, l.LotID, take); err != nil {
+			return nil, err
+		}
+		l.Quantity = take
+		picks = append(picks, l)
+		remaining = remaining.Sub(take)
+	}
+	// the rest must not be the expired units skipped above
+	if !withExpired && remaining.GreaterThan(untracked) {
+		return nil, ErrLotsExpired
+	}
+	return picks, nil
+}
+
+func takeFromLot(ctx context.Context, tx pgx.Tx, lotID int64, quantity decimal.Decimal) error {
+	_, err := tx.Exec(
+		ctx,
+		`UPDATE stock_lot SET quantity = quantity - $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+		quantity, lotID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update lot: %w", err)
+	}
+	return nil
+}
+
+// LotsJSON encodes picks for transaction.lots; no picks is SQL NULL. The
+// text form is sent so pgx does not treat it as binary jsonb.
+func LotsJSON(picks []LotPick) (*string, error) {
+	if len(picks) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(picks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lots: %w", err)
+	}
+	lots := string(data)
+	return &lots, nil
+}
+
+// Execer is the write side shared by *pgxpool.Pool and pgx.Tx.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// FlagExpiringLots marks lots with stock left that expire within
+// ExpiryWarningDays, including lots already expired. Lots are flagged once;
+// it returns how many were flagged by this run.
+func FlagExpiringLots(ctx context.Context, db Execer) (int64, error) {
+	tag, err := db.Exec(
+		ctx,
+		`UPDATE stock_lot SET near_expiry_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE quantity > 0 AND near_expiry_date IS NULL AND expiry_date <= CURRENT_DATE + $1::int`,
+		ExpiryWarningDays,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to flag expiring lots: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,71 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestPickLotsSkipsExpiredLots(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+
+	productID := insertProduct(t, tx, "TEST-LOTS", false)
+	stockID := insertStock(t, tx, 1, productID, decimal.NewFromInt(30))
+	// expiry is compared with the database's CURRENT_DATE, not the local clock
+	var today time.Time
+	if err := tx.QueryRow(ctx, `SELECT CURRENT_DATE`).Scan(&today); err != nil {
+		t.Fatal(err)
+	}
+	expired := today.AddDate(0, 0, -3)
+	nearExpiry := today.AddDate(0, 0, 5)
+	for _, lot := range []struct {
+		number string
+		expiry *time.Time
+	}{
+		{"UNDATED", nil},
+		{"NEAR", &nearExpiry},
+		{"EXPIRED", &expired},
+	} {
+		if _, err := ReceiveLot(ctx, tx, testTenantID, stockID, lot.number, lot.expiry, decimal.NewFromInt(10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	picks, err := PickLots(ctx, tx, stockID, decimal.NewFromInt(15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		number   string
+		quantity int64
+	}{{"NEAR", 10}, {"UNDATED", 5}}
+	if len(picks) != len(want) {
+		t.Fatalf("picked %d lots, want %d: %+v", len(picks), len(want), picks)
+	}
+	for i, w := range want {
+		if picks[i].LotNumber != w.number || !picks[i].Quantity.Equal(decimal.NewFromInt(w.quantity)) {
+			t.Errorf("pick %d = %s x %s, want %s x %d", i, picks[i].LotNumber, picks[i].Quantity, w.number, w.quantity)
+		}
+	}
+
+	// what is left on the shelf is 5 undated units and the 10 expired ones
+	if _, err := tx.Exec(ctx, `UPDATE stock SET quantity = 15 WHERE id = $1`, stockID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PickLots(ctx, tx, stockID, decimal.NewFromInt(10)); !errors.Is(err, ErrLotsExpired) {
+		t.Fatalf("picking into expired stock: err = %v, want ErrLotsExpired", err)
+	}
+
+	// a loss is taken from the expired lot first
+	picks, err = PickLotsForLoss(ctx, tx, stockID, decimal.NewFromInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(picks) != 1 || picks[0].LotNumber != "EXPIRED" {
+		t.Errorf("loss picks = %+v, want the expired lot", picks)
+	}
+}
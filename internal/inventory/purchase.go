@@ -0,0 +1,265 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"atlasq/internal/numeric"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Purchase order statuses stored in purchase_order.status.
+const (
+	PurchaseOrderOpen     = "OPEN"
+	PurchaseOrderPartial  = "PARTIAL"
+	PurchaseOrderReceived = "RECEIVED"
+	// PurchaseOrderClosed orders were closed by hand, short or not.
+	PurchaseOrderClosed = "CLOSED"
+)
+
+var (
+	ErrPurchaseOrderNotFound = errors.New("purchase order not found")
+	ErrPurchaseOrderClosed   = errors.New("purchase order is already received or closed")
+)
+
+// OverReceiptError is returned when a receipt would take a line past its
+// ordered quantity plus the order's tolerance.
+type OverReceiptError struct {
+	LineID    int64
+	ProductID int64
+	Allowed   decimal.Decimal
+	Received  decimal.Decimal
+}
+
+func (e *OverReceiptError) Error() string {
+	return fmt.Sprintf("line %d (product_id=%d) would receive %s, at most %s allowed", e.LineID, e.ProductID, e.Received, e.Allowed)
+}
+
+// PurchaseLineError is returned when a receipt names a line that is not on
+// the purchase order.
+type PurchaseLineError struct {
+	LineID int64
+}
+
+func (e *PurchaseLineError) Error() string {
+	return fmt.Sprintf("line %d is not on the purchase order", e.LineID)
+}
+
+// MaxReceivable is the most of ordered a line may receive with tolerance
+// percent over-receipt allowed.
+func MaxReceivable(ordered, tolerance decimal.Decimal) decimal.Decimal {
+	return ordered.Add(ordered.Mul(tolerance).Div(decimal.NewFromInt(100))).Round(numeric.QuantityScale)
+}
+
+// LineComplete reports whether received is within tolerance percent under
+// ordered, so the line needs no further receipt.
+func LineComplete(ordered, received, tolerance decimal.Decimal) bool {
+	min := ordered.Sub(ordered.Mul(tolerance).Div(decimal.NewFromInt(100)))
+	return received.GreaterThanOrEqual(min)
+}
+
+// ReceiptLine receives quantity of one purchase order line. Quantity and
+// UnitCost are in Unit, or the base unit when it is empty; without a
+// UnitCost the line's ordered cost is used.
+type ReceiptLine struct {
+	LineID     int64
+	Quantity   decimal.Decimal
+	Unit       string
+	UnitCost   *decimal.Decimal
+	LotNumber  string
+	ExpiryDate *time.Time
+	Serials    []string
+}
+
+// ReceivedLine is one line of a goods receipt, in the base unit.
+type ReceivedLine struct {
+	LineID           int64           `json:"line_id"`
+	ProductID        int64           `json:"product_id"`
+	Quantity         decimal.Decimal `json:"quantity"`
+	UnitCost         decimal.Decimal `json:"unit_cost"`
+	Received         decimal.Decimal `json:"received"`
+	Ordered          decimal.Decimal `json:"ordered"`
+	BackordersFilled decimal.Decimal `json:"backorders_filled"`
+}
+
+// GoodsReceipt is a receipt against a purchase order and the order status
+// it left behind.
+type GoodsReceipt struct {
+	ID              int64          `json:"id"`
+	PurchaseOrderID int64          `json:"purchase_order_id"`
+	Status          string         `json:"status"`
+	Lines           []ReceivedLine `json:"lines"`
+}
+
+// ReceivePurchaseOrder receives lines against purchase order poID into its
+// warehouse. Lines may be received in several receipts; each may go over
+// the ordered quantity by the order's tolerance. The order becomes RECEIVED
+// once every line is complete within tolerance, PARTIAL before that.
+func ReceivePurchaseOrder(ctx context.Context, tx pgx.Tx, tenantID, poID int64, lines []ReceiptLine) (*GoodsReceipt, error) {
+	var warehouseID int64
+	var status string
+	var tolerance decimal.Decimal
+	err := tx.QueryRow(
+		ctx,
+		`SELECT warehouse_id, status, tolerance_percent FROM purchase_order WHERE id = $1 AND tenant_id = $2 FOR UPDATE`,
+		poID, tenantID,
+	).Scan(&warehouseID, &status, &tolerance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load purchase order: %w", err)
+	}
+	if status != PurchaseOrderOpen && status != PurchaseOrderPartial {
+		return nil, ErrPurchaseOrderClosed
+	}
+
+	type orderLine struct {
+		productID int64
+		ordered   decimal.Decimal
+		received  decimal.Decimal
+		unitCost  decimal.Decimal
+	}
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, product_id, quantity, received, unit_cost FROM purchase_order_line WHERE purchase_order_id = $1 ORDER BY id FOR UPDATE`,
+		poID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load purchase order lines: %w", err)
+	}
+	orderLines := map[int64]*orderLine{}
+	for rows.Next() {
+		var id int64
+		l := &orderLine{}
+		if err := rows.Scan(&id, &l.productID, &l.ordered, &l.received, &l.unitCost); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan purchase order line: %w", err)
+		}
+		orderLines[id] = l
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load purchase order lines: %w", err)
+	}
+
+	// quantities and costs are converted to the base unit the order is kept in
+	items := make([]tasks.OrderItem, 0, len(lines))
+	productIDs := make([]int64, 0, len(lines))
+	for _, line := range lines {
+		l, ok := orderLines[line.LineID]
+		if !ok {
+			return nil, &PurchaseLineError{LineID: line.LineID}
+		}
+		items = append(items, tasks.OrderItem{ProductID: l.productID, Quantity: line.Quantity, Unit: line.Unit})
+		productIDs = append(productIDs, l.productID)
+	}
+	units, err := LoadUnits(ctx, tx, tenantID, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	items, err = ToBase(items, units)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &GoodsReceipt{PurchaseOrderID: poID, Lines: []ReceivedLine{}}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO goods_receipt (tenant_id, purchase_order_id) VALUES ($1, $2) RETURNING id`,
+		tenantID, poID,
+	).Scan(&receipt.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create goods receipt: %w", err)
+	}
+
+	for i, line := range lines {
+		l := orderLines[line.LineID]
+		item := items[i]
+		received := l.received.Add(item.Quantity)
+		if allowed := MaxReceivable(l.ordered, tolerance); received.GreaterThan(allowed) {
+			return nil, &OverReceiptError{LineID: line.LineID, ProductID: l.productID, Allowed: allowed, Received: received}
+		}
+
+		r := Receipt{
+			ProductID:       l.productID,
+			WarehouseID:     warehouseID,
+			Quantity:        item.Quantity,
+			UnitCost:        &l.unitCost,
+			LotNumber:       line.LotNumber,
+			ExpiryDate:      line.ExpiryDate,
+			Serials:         line.Serials,
+			PurchaseOrderID: poID,
+		}
+		if item.Unit != "" {
+			factor, _ := units[l.productID].Factor(item.Unit)
+			r.Unit, r.UnitFactor = item.Unit, factor
+			if line.UnitCost != nil {
+				cost := line.UnitCost.Div(factor).Round(numeric.MoneyScale)
+				r.UnitCost = &cost
+			}
+		} else if line.UnitCost != nil {
+			r.UnitCost = line.UnitCost
+		}
+
+		result, err := ReceiveStock(ctx, tx, tenantID, r)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE purchase_order_line SET received = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+			received, line.LineID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update purchase order line: %w", err)
+		}
+		var lotNumber *string
+		if line.LotNumber != "" {
+			lotNumber = &line.LotNumber
+		}
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO goods_receipt_line (goods_receipt_id, purchase_order_line_id, quantity, unit_cost, lot_number, serials)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			receipt.ID, line.LineID, item.Quantity, *r.UnitCost, lotNumber, line.Serials,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert goods receipt line: %w", err)
+		}
+
+		l.received = received
+		receipt.Lines = append(receipt.Lines, ReceivedLine{
+			LineID:           line.LineID,
+			ProductID:        l.productID,
+			Quantity:         item.Quantity,
+			UnitCost:         *r.UnitCost,
+			Received:         received,
+			Ordered:          l.ordered,
+			BackordersFilled: result.BackordersFilled,
+		})
+	}
+
+	receipt.Status = PurchaseOrderReceived
+	for _, l := range orderLines {
+		if !LineComplete(l.ordered, l.received, tolerance) {
+			receipt.Status = PurchaseOrderPartial
+			break
+		}
+	}
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE purchase_order SET status = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
+		receipt.Status, poID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update purchase order: %w", err)
+	}
+	return receipt, nil
+}
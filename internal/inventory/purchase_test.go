@@ -0,0 +1,41 @@
+package inventory
+
+import "testing"
+
+func TestMaxReceivable(t *testing.T) {
+	tests := []struct {
+		ordered, tolerance, want string
+	}{
+		{"100", "0", "100"},
+		{"100", "5", "105"},
+		{"10", "2.5", "10.25"},
+		{"3", "33.33", "3.9999"},
+		{"3", "33.33333", "4"},
+		{"0.5", "10", "0.55"},
+	}
+	for _, tt := range tests {
+		if got := MaxReceivable(dec(tt.ordered), dec(tt.tolerance)); !got.Equal(dec(tt.want)) {
+			t.Errorf("MaxReceivable(%s, %s%%) = %s, want %s", tt.ordered, tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func TestLineComplete(t *testing.T) {
+	tests := []struct {
+		ordered, received, tolerance string
+		want                         bool
+	}{
+		{"100", "100", "0", true},
+		{"100", "99.9999", "0", false},
+		{"100", "120", "0", true},
+		{"100", "95", "5", true},
+		{"100", "94.99", "5", false},
+		{"10", "0", "100", true},
+		{"10", "0", "0", false},
+	}
+	for _, tt := range tests {
+		if got := LineComplete(dec(tt.ordered), dec(tt.received), dec(tt.tolerance)); got != tt.want {
+			t.Errorf("LineComplete(%s, %s, %s%%) = %v, want %v", tt.ordered, tt.received, tt.tolerance, got, tt.want)
+		}
+	}
+}
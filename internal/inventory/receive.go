@@ -0,0 +1,159 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"atlasq/internal/correlation"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Receipt is stock received into a warehouse, in the product's base unit.
+// Unit and UnitFactor record the unit it was entered in for the ledger.
+type Receipt struct {
+	ProductID       int64
+	WarehouseID     int64
+	Quantity        decimal.Decimal
+	Unit            string
+	UnitFactor      decimal.Decimal
+	UnitCost        *decimal.Decimal
+	LotNumber       string
+	ExpiryDate      *time.Time
+	Serials         []string
+	PurchaseOrderID int64
+}
+
+// ReceiptResult is what a receipt did to its stock row.
+type ReceiptResult struct {
+	StockID          int64
+	Quantity         decimal.Decimal
+	Cost             Cost
+	BackordersFilled decimal.Decimal
+}
+
+// ReceiveStock adds a receipt to stock, for /api/v1/stocks and goods
+// receipts alike: the stock row is created on the first receipt, the units
+// go into the named lot, serials are registered, a cost layer is added, a
+// RECEIVE ledger row is written and open backorders are filled.
+func ReceiveStock(ctx context.Context, tx pgx.Tx, tenantID int64, r Receipt) (*ReceiptResult, error) {
+	result := &ReceiptResult{}
+	var before decimal.Decimal
+	var frozenCountID *int64
+	err := tx.QueryRow(
+		ctx,
+		`SELECT id, quantity, frozen_count_id FROM stock WHERE product_id = $1 AND warehouse_id = $2 AND tenant_id = $3 FOR UPDATE`,
+		r.ProductID, r.WarehouseID, tenantID,
+	).Scan(&result.StockID, &before, &frozenCountID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if err := CheckStockable(ctx, tx, tenantID, r.ProductID); err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow(
+			ctx,
+			`INSERT INTO stock (
+				tenant_id, warehouse_id, product_id,
+				minimum, quantity, reserve, on_hand, status, source,
+				create_date, update_date, row_create_date, row_update_date
+			) VALUES (
+				$1, $2, $3,
+				0, $4, 0, $4, true, 'RECEIPT',
+				CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			) RETURNING id`,
+			tenantID, r.WarehouseID, r.ProductID, r.Quantity,
+		).Scan(&result.StockID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stock: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to load stock: %w", err)
+	case frozenCountID != nil:
+		return nil, &StockFrozenError{ProductID: r.ProductID, CountID: *frozenCountID}
+	default:
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE stock SET quantity = quantity + $1, on_hand = quantity + $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+			WHERE id = $2`,
+			r.Quantity, result.StockID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update stock: %w", err)
+		}
+	}
+	after := before.Add(r.Quantity)
+
+	var picks []LotPick
+	if r.LotNumber != "" {
+		pick, err := ReceiveLot(ctx, tx, tenantID, result.StockID, r.LotNumber, r.ExpiryDate, r.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		picks = append(picks, *pick)
+	}
+	lots, err := LotsJSON(picks)
+	if err != nil {
+		return nil, err
+	}
+	serials, err := ReceiveSerials(ctx, tx, tenantID, r.ProductID, result.StockID, r.Quantity, r.Serials)
+	if err != nil {
+		return nil, err
+	}
+	result.Cost, err = ReceiveCost(ctx, tx, tenantID, result.StockID, before, r.Quantity, r.UnitCost)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckLowStock(ctx, tx, result.StockID); err != nil {
+		return nil, err
+	}
+
+	model := "STOCK"
+	var purchaseOrderID *int64
+	if r.PurchaseOrderID != 0 {
+		model, purchaseOrderID = "PURCHASE_ORDER", &r.PurchaseOrderID
+	}
+	var unit *string
+	var unitFactor *decimal.Decimal
+	if r.Unit != "" {
+		unit, unitFactor = &r.Unit, &r.UnitFactor
+	}
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO transaction (
+			model, event, teanant_id, product_id, warehouse_id, stock_id,
+			quantity_old, quantity_change, quantity_new,
+			reserve_old, reserve_change, reserve_new,
+			on_hand_old, on_hand_change, on_hand_new,
+			status, unit, unit_factor, lots, serials, unit_cost, total_cost, purchase_order_id, request_id,
+			create_date, update_date, row_create_date, row_update_date
+		) VALUES (
+			$1, $2, $3, $4, $5, $6,
+			$7, $8, $9,
+			$10, $11, $12,
+			$13, $14, $15,
+			$16, $17, $18, $19, $20, $21, $22, $23, NULLIF($24, ''),
+			CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		)`,
+		model, "RECEIVE", tenantID, r.ProductID, r.WarehouseID, result.StockID,
+		before, r.Quantity, after,
+		0, 0, 0, // reserve
+		before, r.Quantity, after,
+		true, unit, unitFactor, lots, serials, result.Cost.UnitCost, result.Cost.Total, purchaseOrderID, correlation.ID(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert transaction: %w", err)
+	}
+
+	result.BackordersFilled, err = FillBackorders(ctx, tx, tenantID, r.WarehouseID, r.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow(ctx, `SELECT quantity FROM stock WHERE id = $1`, result.StockID).Scan(&result.Quantity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stock: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,249 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	tasks "atlasq/internal/tasks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// Serial statuses stored in serial_number.status.
+const (
+	SerialInStock = "IN_STOCK"
+	// SerialReserved units are held for an order waiting in the queue.
+	SerialReserved = "RESERVED"
+	SerialSold     = "SOLD"
+	// SerialReturned units were received again after a sale and can be sold.
+	SerialReturned = "RETURNED"
+)
+
+// SerialError is returned when serials do not match the product, quantity
+// or stock they are used with.
+type SerialError struct {
+	ProductID int64
+	Serial    string
+	Reason    string
+}
+
+func (e *SerialError) Error() string {
+	if e.Serial == "" {
+		return fmt.Sprintf("serials of product_id=%d: %s", e.ProductID, e.Reason)
+	}
+	return fmt.Sprintf("serial %q of product_id=%d %s", e.Serial, e.ProductID, e.Reason)
+}
+
+type reservationKey struct{}
+
+// WithReservation marks ctx as processing the queued order whose serials
+// were reserved under id, so TakeSerials may sell them.
+func WithReservation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reservationKey{}, id)
+}
+
+func reservation(ctx context.Context) string {
+	id, _ := ctx.Value(reservationKey{}).(string)
+	return id
+}
+
+func isSerialized(ctx context.Context, q Querier, productID int64) (bool, error) {
+	var serialized bool
+	err := q.QueryRow(ctx, `SELECT serialized FROM product WHERE id = $1`, productID).Scan(&serialized)
+	if err != nil {
+		return false, fmt.Errorf("failed to load product: %w", err)
+	}
+	return serialized, nil
+}
+
+// checkSerialCount makes sure serials name quantity distinct units.
+func checkSerialCount(productID int64, quantity decimal.Decimal, serials []string) error {
+	if !quantity.IsInteger() {
+		return &SerialError{ProductID: productID, Reason: "quantity must be a whole number"}
+	}
+	if int64(len(serials)) != quantity.Abs().IntPart() {
+		return &SerialError{ProductID: productID, Reason: "need one serial per unit"}
+	}
+	seen := map[string]bool{}
+	for _, s := range serials {
+		if s == "" || len(s) > 100 {
+			return &SerialError{ProductID: productID, Serial: s, Reason: "must be 1 to 100 characters"}
+		}
+		if seen[s] {
+			return &SerialError{ProductID: productID, Serial: s, Reason: "is listed twice"}
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+// CheckOrderSerials validates the serials named on order items: only
+// serialized products take them, and then one per unit ordered. Items
+// without serials are allocated serials when they are deducted.
+func CheckOrderSerials(ctx context.Context, q Querier, items []tasks.OrderItem) error {
+	for _, item := range items {
+		if len(item.Serials) == 0 {
+			continue
+		}
+		serialized, err := isSerialized(ctx, q, item.ProductID)
+		if err != nil {
+			return err
+		}
+		if !serialized {
+			return &SerialError{ProductID: item.ProductID, Reason: "product is not serialized"}
+		}
+		if err := checkSerialCount(item.ProductID, item.Quantity, item.Serials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReceiveSerials registers the serials of units received into stockID. A
+// serialized product needs exactly one serial per unit; a serial that was
+// sold before comes back as RETURNED. It returns the serials for the ledger.
+func ReceiveSerials(ctx context.Context, tx pgx.Tx, tenantID, productID, stockID int64, quantity decimal.Decimal, serials []string) ([]string, error) {
+	serialized, err := isSerialized(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !serialized {
+		if len(serials) > 0 {
+			return nil, &SerialError{ProductID: productID, Reason: "product is not serialized"}
+		}
+		return nil, nil
+	}
+	if err := checkSerialCount(productID, quantity, serials); err != nil {
+		return nil, err
+	}
+
+	for _, s := range serials {
+		var id int64
+		err := tx.QueryRow(
+			ctx,
+			`INSERT INTO serial_number (tenant_id, product_id, stock_id, serial, status)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (tenant_id, product_id, serial) DO UPDATE SET
+				status = $6,
+				stock_id = EXCLUDED.stock_id,
+				update_date = CURRENT_TIMESTAMP,
+				row_update_date = CURRENT_TIMESTAMP
+			WHERE serial_number.status = $7
+			RETURNING id`,
+			tenantID, productID, stockID, s, SerialInStock, SerialReturned, SerialSold,
+		).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, &SerialError{ProductID: productID, Serial: s, Reason: "is already in stock"}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to register serial: %w", err)
+		}
+	}
+	return serials, nil
+}
+
+// TakeSerials marks quantity units of stockID as sold. Named serials are
+// taken from the ones held by stockID, so a line split over warehouses takes
+// its share from each; without names the oldest units go first. It returns
+// the serials for the ledger, or nil for products that are not serialized.
+func TakeSerials(ctx context.Context, tx pgx.Tx, tenantID, productID, stockID int64, quantity decimal.Decimal, serials []string) ([]string, error) {
+	serialized, err := isSerialized(ctx, tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !serialized {
+		if len(serials) > 0 {
+			return nil, &SerialError{ProductID: productID, Reason: "product is not serialized"}
+		}
+		return nil, nil
+	}
+	if !quantity.IsInteger() {
+		return nil, &SerialError{ProductID: productID, Reason: "quantity must be a whole number"}
+	}
+	n := quantity.IntPart()
+
+	// reserved serials are only sold by the order that reserved them, see WithReservation
+	query := `SELECT serial FROM serial_number
+		WHERE tenant_id = $1 AND product_id = $2 AND stock_id = $3 AND status = ANY($4)
+		ORDER BY id LIMIT $5 FOR UPDATE`
+	args := []interface{}{tenantID, productID, stockID, []string{SerialInStock, SerialReturned}, n}
+	if len(serials) > 0 {
+		query = `SELECT serial FROM serial_number
+			WHERE tenant_id = $1 AND product_id = $2 AND stock_id = $3 AND serial = ANY($6)
+				AND (status = ANY($4) OR (status = $7 AND reserved_by = $8))
+			ORDER BY id LIMIT $5 FOR UPDATE`
+		args = []interface{}{tenantID, productID, stockID, []string{SerialInStock, SerialReturned}, n, serials, SerialReserved, reservation(ctx)}
+	}
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load serials: %w", err)
+	}
+	var taken []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan serial: %w", err)
+		}
+		taken = append(taken, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load serials: %w", err)
+	}
+	if int64(len(taken)) < n {
+		return nil, &SerialError{ProductID: productID, Reason: "not enough serials available in the warehouse"}
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE serial_number SET status = $1, reserved_by = NULL, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE tenant_id = $2 AND product_id = $3 AND serial = ANY($4)`,
+		SerialSold, tenantID, productID, taken,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update serials: %w", err)
+	}
+	return taken, nil
+}
+
+// ReserveSerials holds the serials named on items for the queued order
+// reservationID. Only a task run under WithReservation(ctx, reservationID)
+// can sell them.
+func ReserveSerials(ctx context.Context, tx pgx.Tx, tenantID int64, reservationID string, items []tasks.OrderItem) error {
+	for _, item := range items {
+		if len(item.Serials) == 0 {
+			continue
+		}
+		tag, err := tx.Exec(
+			ctx,
+			`UPDATE serial_number SET status = $1, reserved_by = $2, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+			WHERE tenant_id = $3 AND product_id = $4 AND serial = ANY($5) AND status = ANY($6)`,
+			SerialReserved, reservationID, tenantID, item.ProductID, item.Serials, []string{SerialInStock, SerialReturned},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to reserve serials: %w", err)
+		}
+		if tag.RowsAffected() != int64(len(item.Serials)) {
+			return &SerialError{ProductID: item.ProductID, Reason: "not all serials are in stock"}
+		}
+	}
+	return nil
+}
+
+// ReleaseSerials puts the serials still reserved under reservationID back
+// in stock, for orders that failed or shipped less than they named.
+func ReleaseSerials(ctx context.Context, db Execer, tenantID int64, reservationID string) error {
+	_, err := db.Exec(
+		ctx,
+		`UPDATE serial_number SET status = $1, reserved_by = NULL, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+		WHERE tenant_id = $2 AND reserved_by = $3 AND status = $4`,
+		SerialInStock, tenantID, reservationID, SerialReserved,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release serials: %w", err)
+	}
+	return nil
+}
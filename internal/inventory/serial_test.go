@@ -0,0 +1,69 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"atlasq/internal/tasks"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestReservedSerialsBelongToTheirOrder(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+
+	productID := insertProduct(t, tx, "TEST-SERIAL", true)
+	stockID := insertStock(t, tx, 1, productID, decimal.NewFromInt(3))
+	if _, err := ReceiveSerials(ctx, tx, testTenantID, productID, stockID, decimal.NewFromInt(3), []string{"SN-1", "SN-2", "SN-3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []tasks.OrderItem{{ProductID: productID, Quantity: decimal.NewFromInt(2), Serials: []string{"SN-1", "SN-2"}}}
+	if err := ReserveSerials(ctx, tx, testTenantID, "order-a", items); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReserveSerials(ctx, tx, testTenantID, "order-b", items); err == nil {
+		t.Fatal("reserved serials were reserved again")
+	}
+
+	// another order naming the same serials cannot sell them
+	var serialErr *SerialError
+	_, err := TakeSerials(WithReservation(ctx, "order-b"), tx, testTenantID, productID, stockID, decimal.NewFromInt(1), []string{"SN-1"})
+	if !errors.As(err, &serialErr) {
+		t.Fatalf("order-b took a serial reserved by order-a: %v", err)
+	}
+	_, err = TakeSerials(ctx, tx, testTenantID, productID, stockID, decimal.NewFromInt(1), []string{"SN-1"})
+	if !errors.As(err, &serialErr) {
+		t.Fatalf("a task without a reservation took a reserved serial: %v", err)
+	}
+
+	// the owner ships one of the two, the other goes back in stock on release
+	taken, err := TakeSerials(WithReservation(ctx, "order-a"), tx, testTenantID, productID, stockID, decimal.NewFromInt(1), []string{"SN-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taken) != 1 || taken[0] != "SN-1" {
+		t.Fatalf("taken = %v, want [SN-1]", taken)
+	}
+	if err := ReleaseSerials(ctx, tx, testTenantID, "order-a"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"SN-1": SerialSold, "SN-2": SerialInStock, "SN-3": SerialInStock}
+	for serial, status := range want {
+		var got string
+		var reservedBy *string
+		err := tx.QueryRow(ctx,
+			`SELECT status, reserved_by FROM serial_number WHERE tenant_id = $1 AND product_id = $2 AND serial = $3`,
+			testTenantID, productID, serial,
+		).Scan(&got, &reservedBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != status || reservedBy != nil {
+			t.Errorf("%s: status %s reserved_by %v, want %s and no reservation", serial, got, reservedBy, status)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"atlasq/internal/correlation"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// InsufficientStockError is returned when a stock row cannot cover the ordered quantity.
+type InsufficientStockError struct {
+	ProductID int64
+	Stock     decimal.Decimal
+	Required  decimal.Decimal
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("not enough stock for product_id=%d , stockQty=%s , item.required=%s", e.ProductID, e.Stock, e.Required)
+}
+
+// ProductNotFoundError is returned when an order names a product that does
+// not belong to the tenant or has been archived.
+type ProductNotFoundError struct {
+	ProductID int64
+}
+
+func (e *ProductNotFoundError) Error() string {
+	return fmt.Sprintf("product not found: product_id=%d", e.ProductID)
+}
+
+// VariantRequiredError is returned when an order or receipt names a parent
+// product; stock is only kept for its variants.
+type VariantRequiredError struct {
+	ProductID int64
+}
+
+func (e *VariantRequiredError) Error() string {
+	return fmt.Sprintf("product has variants, use a variant: product_id=%d", e.ProductID)
+}
+
+// CheckProducts makes sure every item refers to an active product of the
+// tenant that can hold stock itself, i.e. not a parent with variants.
+func CheckProducts(ctx context.Context, tx pgx.Tx, tenantID int64, items []tasks.OrderItem) error {
+	productIDs := make([]int64, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT p.id, EXISTS(SELECT 1 FROM product v WHERE v.parent_id = p.id)
+		FROM product p
+		WHERE p.tenant_id = $1 AND p.id = ANY($2) AND p.archived_date IS NULL`,
+		tenantID, productIDs,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load products: %w", err)
+	}
+	defer rows.Close()
+
+	found := map[int64]bool{}
+	hasVariants := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		var variants bool
+		if err := rows.Scan(&id, &variants); err != nil {
+			return fmt.Errorf("failed to scan product: %w", err)
+		}
+		found[id] = true
+		hasVariants[id] = variants
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load products: %w", err)
+	}
+
+	for _, item := range items {
+		if !found[item.ProductID] {
+			return &ProductNotFoundError{ProductID: item.ProductID}
+		}
+		if hasVariants[item.ProductID] {
+			return &VariantRequiredError{ProductID: item.ProductID}
+		}
+	}
+	return nil
+}
+
+// Line is one product deducted from stock, in its base unit. Lines expanded
+// from a bundle keep the bundle product so the ledger can reference it, and
+// lines entered in another unit keep that unit for the ledger. Serials are
+// the ones named on the order, if any.
+type Line struct {
+	ProductID       int64           `json:"product_id"`
+	Quantity        decimal.Decimal `json:"quantity"`
+	BundleProductID int64           `json:"bundle_product_id,omitempty"`
+	Unit            string          `json:"-"`
+	UnitFactor      decimal.Decimal `json:"-"`
+	Serials         []string        `json:"-"`
+}
+
+// DeductLines deducts every line from the warehouse stock and writes one
+// ORDER/ISSUE transaction row per line. Shared by /api/v1/orders and the worker.
+func DeductLines(ctx context.Context, tx pgx.Tx, tenantID, warehouseID int64, lines []Line) error {
+	for _, item := range lines {
+		var bundleProductID *int64
+		if item.BundleProductID != 0 {
+			bundleProductID = &item.BundleProductID
+		}
+		var unit *string
+		var unitFactor *decimal.Decimal
+		if item.Unit != "" {
+			unit, unitFactor = &item.Unit, &item.UnitFactor
+		}
+
+		var stockID int64
+		var stockQty, reserveQty, onHandQty decimal.Decimal
+		var frozenCountID *int64
+
+		// หา stock
+		// rows are only created by receiving flows, a missing row means nothing to ship
+		err := tx.QueryRow(
+			ctx,
+			`SELECT id, quantity, reserve, on_hand, frozen_count_id
+            FROM stock
+            WHERE product_id=$1 AND warehouse_id=$2 AND tenant_id=$3`,
+			item.ProductID, warehouseID, tenantID,
+		).Scan(&stockID, &stockQty, &reserveQty, &onHandQty, &frozenCountID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &InsufficientStockError{ProductID: item.ProductID, Stock: decimal.Zero, Required: item.Quantity}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load stock: %w", err)
+		}
+		if frozenCountID != nil {
+			return &StockFrozenError{ProductID: item.ProductID, CountID: *frozenCountID}
+		}
+
+		// เช็ค stock พอไหม
+		if stockQty.LessThan(item.Quantity) {
+			return &InsufficientStockError{ProductID: item.ProductID, Stock: stockQty, Required: item.Quantity}
+		}
+
+		// pick from bins and lots (FEFO) first, then reduce the warehouse total
+		if err := PickLocations(ctx, tx, stockID, item.Quantity); err != nil {
+			return err
+		}
+		picks, err := PickLots(ctx, tx, stockID, item.Quantity)
+		if err != nil {
+			return err
+		}
+		lots, err := LotsJSON(picks)
+		if err != nil {
+			return err
+		}
+		serials, err := TakeSerials(ctx, tx, tenantID, item.ProductID, stockID, item.Quantity, item.Serials)
+		if err != nil {
+			return err
+		}
+		cost, err := IssueCost(ctx, tx, tenantID, stockID, stockQty, item.Quantity)
+		if err != nil {
+			return err
+		}
+
+		newQty := stockQty.Sub(item.Quantity)
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE stock
+             SET quantity=$1, on_hand=$1, update_date=CURRENT_TIMESTAMP, row_update_date=CURRENT_TIMESTAMP
+             WHERE id=$2`,
+			newQty, stockID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update stock: %w", err)
+		}
+		if err := CheckLowStock(ctx, tx, stockID); err != nil {
+			return err
+		}
+
+		// insert transaction log
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO transaction (
+                model,event,teanant_id,product_id,warehouse_id,stock_id,
+                quantity_old,quantity_change,quantity_new,
+                reserve_old,reserve_change,reserve_new,
+                on_hand_old,on_hand_change,on_hand_new,
+                status,bundle_product_id,unit,unit_factor,lots,serials,unit_cost,total_cost,request_id,create_date,update_date,row_create_date,row_update_date
+            ) VALUES (
+                $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,NULLIF($24,''),
+                CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP
+            )`,
+			"ORDER", "ISSUE", tenantID, item.ProductID, warehouseID, stockID,
+			stockQty, item.Quantity.Neg(), newQty,
+			reserveQty, decimal.Zero, reserveQty,
+			onHandQty, item.Quantity.Neg(), newQty,
+			true, bundleProductID, unit, unitFactor, lots, serials, cost.UnitCost, cost.Total, correlation.ID(ctx),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert transaction: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"atlasq/internal/numeric"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/shopspring/decimal"
+)
+
+// UnknownUnitError is returned when an item names a unit its product does not define.
+type UnknownUnitError struct {
+	ProductID int64
+	Unit      string
+}
+
+func (e *UnknownUnitError) Error() string {
+	return fmt.Sprintf("unknown unit %q for product_id=%d", e.Unit, e.ProductID)
+}
+
+// Units are a product's base unit, in which stock is kept, and the factors
+// of its other units (1 unit = factor base units).
+type Units struct {
+	Base    string
+	Factors map[string]decimal.Decimal
+}
+
+// Factor returns how many base units one unit holds. The empty unit is the
+// base unit.
+func (u Units) Factor(unit string) (decimal.Decimal, bool) {
+	if unit == "" || unit == u.Base {
+		return decimal.NewFromInt(1), true
+	}
+	f, ok := u.Factors[unit]
+	return f, ok
+}
+
+// NormalizeUnit is the form unit codes are stored and compared in.
+func NormalizeUnit(unit string) string {
+	return strings.ToUpper(strings.TrimSpace(unit))
+}
+
+// LoadUnits returns the units of the tenant's products among productIDs.
+func LoadUnits(ctx context.Context, q Querier, tenantID int64, productIDs []int64) (map[int64]Units, error) {
+	rows, err := q.Query(
+		ctx,
+		`SELECT p.id, p.base_unit, u.code, u.factor
+		FROM product p
+		LEFT JOIN product_unit u ON u.product_id = p.id
+		WHERE p.tenant_id = $1 AND p.id = ANY($2)`,
+		tenantID, productIDs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load units: %w", err)
+	}
+	defer rows.Close()
+
+	units := map[int64]Units{}
+	for rows.Next() {
+		var productID int64
+		var base string
+		var code *string
+		var factor decimal.NullDecimal
+		if err := rows.Scan(&productID, &base, &code, &factor); err != nil {
+			return nil, fmt.Errorf("failed to scan unit: %w", err)
+		}
+		u, ok := units[productID]
+		if !ok {
+			u = Units{Base: base, Factors: map[string]decimal.Decimal{}}
+			units[productID] = u
+		}
+		if code != nil && factor.Valid {
+			u.Factors[*code] = factor.Decimal
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load units: %w", err)
+	}
+	return units, nil
+}
+
+// ToBase converts item quantities to the base unit of their products. The
+// unit the item was entered in is kept so the ledger can record it.
+func ToBase(items []tasks.OrderItem, units map[int64]Units) ([]tasks.OrderItem, error) {
+	converted := make([]tasks.OrderItem, 0, len(items))
+	for _, item := range items {
+		u, ok := units[item.ProductID]
+		if !ok {
+			return nil, &ProductNotFoundError{ProductID: item.ProductID}
+		}
+		item.Unit = NormalizeUnit(item.Unit)
+		factor, ok := u.Factor(item.Unit)
+		if !ok {
+			return nil, &UnknownUnitError{ProductID: item.ProductID, Unit: item.Unit}
+		}
+		item.Quantity = item.Quantity.Mul(factor)
+		// the stock columns hold QuantityScale places, do not round silently
+		if err := numeric.CheckScale(item.Quantity, numeric.QuantityScale); err != nil {
+			return nil, fmt.Errorf("quantity of product_id=%d in %s %w", item.ProductID, u.Base, err)
+		}
+		converted = append(converted, item)
+	}
+	return converted, nil
+}
@@ -0,0 +1,61 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+
+	"atlasq/internal/numeric"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestToBase(t *testing.T) {
+	units := map[int64]Units{
+		1: {Base: "PCS", Factors: map[string]decimal.Decimal{"BOX": dec("12"), "PACK": dec("0.25")}},
+		2: {Base: "KG", Factors: map[string]decimal.Decimal{"G": dec("0.001")}},
+	}
+	tests := []struct {
+		name     string
+		item     tasks.OrderItem
+		quantity string
+		unit     string
+		err      error
+	}{
+		{"no unit is the base unit", tasks.OrderItem{ProductID: 1, Quantity: dec("3")}, "3", "", nil},
+		{"base unit named", tasks.OrderItem{ProductID: 1, Quantity: dec("3"), Unit: "PCS"}, "3", "PCS", nil},
+		{"larger unit", tasks.OrderItem{ProductID: 1, Quantity: dec("2"), Unit: "BOX"}, "24", "BOX", nil},
+		{"smaller unit", tasks.OrderItem{ProductID: 1, Quantity: dec("6"), Unit: "PACK"}, "1.5", "PACK", nil},
+		{"units are normalized", tasks.OrderItem{ProductID: 1, Quantity: dec("1"), Unit: " box "}, "12", "BOX", nil},
+		{"grams to kilograms", tasks.OrderItem{ProductID: 2, Quantity: dec("1250"), Unit: "G"}, "1.25", "G", nil},
+		{"too precise in the base unit", tasks.OrderItem{ProductID: 2, Quantity: dec("0.05"), Unit: "G"}, "", "", numeric.ErrTooPrecise},
+		{"unknown unit", tasks.OrderItem{ProductID: 1, Quantity: dec("1"), Unit: "CRATE"}, "", "", &UnknownUnitError{}},
+		{"unknown product", tasks.OrderItem{ProductID: 3, Quantity: dec("1")}, "", "", &ProductNotFoundError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToBase([]tasks.OrderItem{tt.item}, units)
+			switch want := tt.err.(type) {
+			case nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !got[0].Quantity.Equal(dec(tt.quantity)) || got[0].Unit != tt.unit {
+					t.Errorf("ToBase = %s %q, want %s %q", got[0].Quantity, got[0].Unit, tt.quantity, tt.unit)
+				}
+			case *UnknownUnitError:
+				if !errors.As(err, &want) || want.Unit != "CRATE" {
+					t.Errorf("err = %v, want UnknownUnitError for CRATE", err)
+				}
+			case *ProductNotFoundError:
+				if !errors.As(err, &want) || want.ProductID != tt.item.ProductID {
+					t.Errorf("err = %v, want ProductNotFoundError", err)
+				}
+			default:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"atlasq/internal/apierr"
+
+	"go.uber.org/zap"
+)
+
+type levelBody struct {
+	Component string `json:"component"`
+	Level     string `json:"level"`
+}
+
+// LevelHandler reports the level of L() on GET and changes it on PUT with
+// a body like {"level":"debug"}. It does no authentication of its own.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body levelBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				apierr.Write(w, apierr.BadRequest("invalid request body"))
+				return
+			}
+			old := level.Level()
+			if err := SetLevel(body.Level); err != nil {
+				apierr.Write(w, apierr.BadRequest("level must be debug, info, warn, error, dpanic, panic or fatal"))
+				return
+			}
+			L().Warn("log level changed", zap.Stringer("from", old), zap.Stringer("to", level.Level()))
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			apierr.Write(w, apierr.New(http.StatusMethodNotAllowed, apierr.CodeFor(http.StatusMethodNotAllowed), "method not allowed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, levelBody{Component: component, Level: level.String()})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -1,24 +1,96 @@
 package logger
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var l *zap.Logger
+// Components log at their own level, read from LOG_LEVEL_API,
+// LOG_LEVEL_WORKER or LOG_LEVEL_MIGRATOR, then LOG_LEVEL, then info.
+const (
+	ComponentAPI      = "api"
+	ComponentWorker   = "worker"
+	ComponentMigrator = "migrator"
+)
+
+var (
+	l         *zap.Logger
+	level     = zap.NewAtomicLevel()
+	component string
+)
+
+// Init builds L() for service running as component. Besides the levels it
+// reads from the environment:
+//
+//	LOG_SAMPLE_INITIAL, LOG_SAMPLE_THEREAFTER  per second and message, log the
+//	    first INITIAL entries below warn level and every THEREAFTER-th after
+//	    that (0, 100); sampling is off unless LOG_SAMPLE_INITIAL is set
+//	LOG_FILE  also write to this file, rotated at LOG_FILE_MAX_MB (100) and
+//	    kept for LOG_FILE_MAX_BACKUPS files (5) and LOG_FILE_MAX_AGE_DAYS (14)
+//
+// Fields that look like secrets are redacted, see redactCore.
+func Init(service, comp string) error {
+	lvl, err := envLevel(comp)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(lvl)
+	component = comp
 
-func Init(service string) error {
 	encCfg := zap.NewProductionEncoderConfig()
 	encCfg.TimeKey = "@timestamp"
 	encCfg.MessageKey = "message"
 	encCfg.LevelKey = "level"
 	enc := zapcore.NewJSONEncoder(encCfg)
 
-	core := zapcore.NewCore(enc, zapcore.AddSync(os.Stdout), zapcore.InfoLevel)
+	sinks := []zapcore.WriteSyncer{zapcore.AddSync(os.Stdout)}
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		maxMB, err := envInt("LOG_FILE_MAX_MB", 100)
+		if err != nil {
+			return err
+		}
+		maxBackups, err := envInt("LOG_FILE_MAX_BACKUPS", 5)
+		if err != nil {
+			return err
+		}
+		maxAge, err := envInt("LOG_FILE_MAX_AGE_DAYS", 14)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, zapcore.AddSync(&lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxMB,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAge,
+			Compress:   true,
+		}))
+	}
+
+	// the sampler decides in Check, so it has to wrap the redaction
+	var core zapcore.Core = &redactCore{Core: zapcore.NewCore(enc, zapcore.NewMultiWriteSyncer(sinks...), level)}
+
+	initial, err := envInt("LOG_SAMPLE_INITIAL", 0)
+	if err != nil {
+		return err
+	}
+	thereafter, err := envInt("LOG_SAMPLE_THEREAFTER", 100)
+	if err != nil {
+		return err
+	}
+	if initial > 0 {
+		core = &sampleBelowWarn{Core: core, sampled: zapcore.NewSamplerWithOptions(core, time.Second, initial, thereafter)}
+	}
+
 	base := zap.New(core).With(
 		zap.String("service", service),
+		zap.String("component", comp),
 	)
 
 	l = base
@@ -28,15 +100,86 @@ func Init(service string) error {
 
 func L() *zap.Logger {
 	if l == nil {
-		_ = Init("atlasq")
+		_ = Init("atlasq", ComponentAPI)
 	}
 	return l
 }
 
-func WithJob(jobID, queue, source string) *zap.Logger {
-	return L().With(
+// Level is the level of L(), which can be changed while running.
+func Level() zap.AtomicLevel {
+	return level
+}
+
+// Component is the component L() was built for.
+func Component() string {
+	return component
+}
+
+// SetLevel changes the level of L() to text, such as "debug".
+func SetLevel(text string) error {
+	lvl, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(lvl)
+	return nil
+}
+
+// WithJob tags L() with a background job. requestID is the X-Request-ID of
+// the HTTP request that enqueued the job and is left out when empty.
+func WithJob(jobID, queue, source, requestID string) *zap.Logger {
+	fields := []zap.Field{
 		zap.String("job_id", jobID),
 		zap.String("queue", queue),
 		zap.String("source", source),
-	)
+	}
+	if requestID != "" {
+		fields = append(fields, zap.String("request_id", requestID))
+	}
+	return L().With(fields...)
+}
+
+func envLevel(comp string) (zapcore.Level, error) {
+	text := os.Getenv("LOG_LEVEL_" + strings.ToUpper(comp))
+	if text == "" {
+		text = os.Getenv("LOG_LEVEL")
+	}
+	if text == "" {
+		return zapcore.InfoLevel, nil
+	}
+	lvl, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return lvl, fmt.Errorf("invalid log level for %s: %w", comp, err)
+	}
+	return lvl, nil
+}
+
+func envInt(name string, def int) (int, error) {
+	text := os.Getenv(name)
+	if text == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// sampleBelowWarn samples debug and info entries only. Warnings and errors,
+// such as the request line of a 5xx, are always written.
+type sampleBelowWarn struct {
+	zapcore.Core
+	sampled zapcore.Core
+}
+
+func (c *sampleBelowWarn) With(fields []zapcore.Field) zapcore.Core {
+	return &sampleBelowWarn{Core: c.Core.With(fields), sampled: c.sampled.With(fields)}
+}
+
+func (c *sampleBelowWarn) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level >= zapcore.WarnLevel {
+		return c.Core.Check(entry, ce)
+	}
+	return c.sampled.Check(entry, ce)
 }
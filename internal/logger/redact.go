@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sensitiveKeys are the field key fragments whose values are never logged,
+// such as the tenant webhook secret or an Authorization header.
+var sensitiveKeys = []string{"secret", "password", "token", "authorization", "api_key"}
+
+const redacted = "[REDACTED]"
+
+// redactCore replaces the value of every field whose key contains one of
+// sensitiveKeys. Only top-level fields are checked; objects logged with
+// zap.Any or zap.Object are written as they are.
+type redactCore struct {
+	zapcore.Core
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(redact(fields))}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, redact(fields))
+}
+
+func redact(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		if !sensitive(f.Key) {
+			continue
+		}
+		if out == nil {
+			out = append([]zapcore.Field(nil), fields...)
+		}
+		out[i] = zap.String(f.Key, redacted)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
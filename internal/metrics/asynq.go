@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// TaskMiddleware counts and times every task by type.
+func TaskMiddleware(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		if retry, _ := asynq.GetRetryCount(ctx); retry > 0 {
+			TasksRetried.WithLabelValues(t.Type()).Inc()
+		}
+		start := time.Now()
+		err := h.ProcessTask(ctx, t)
+		TaskDuration.WithLabelValues(t.Type()).Observe(time.Since(start).Seconds())
+		TasksProcessed.WithLabelValues(t.Type()).Inc()
+		if err != nil {
+			TasksFailed.WithLabelValues(t.Type()).Inc()
+		}
+		return err
+	})
+}
@@ -0,0 +1,101 @@
+// Package metrics holds the Prometheus metrics of the API and the worker,
+// served on /metrics by both.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shopspring/decimal"
+)
+
+const namespace = "atlasq"
+
+// Registry holds every atlasq metric plus the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	TasksProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_processed_total",
+		Help:      "asynq tasks processed, successful or not, by type.",
+	}, []string{"type"})
+
+	TasksFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_failed_total",
+		Help:      "asynq tasks whose handler returned an error, by type.",
+	}, []string{"type"})
+
+	TasksRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_retried_total",
+		Help:      "asynq task runs that were a retry of an earlier failure, by type.",
+	}, []string{"type"})
+
+	TaskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "asynq task processing time by type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	SerializationRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "serialization_retries_total",
+		Help:      "Stock transactions retried after a serialization failure (SQLSTATE 40001), by stage.",
+	}, []string{"stage"})
+
+	UnitsDeducted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "inventory_units_deducted_total",
+		Help:      "Stock units deducted by committed orders, in base units, by tenant.",
+	}, []string{"tenant_id"})
+
+	InsufficientStockRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "inventory_insufficient_stock_rejections_total",
+		Help:      "Orders given up for insufficient stock after their last retry, by tenant.",
+	}, []string{"tenant_id"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		TasksProcessed,
+		TasksFailed,
+		TasksRetried,
+		TaskDuration,
+		SerializationRetries,
+		UnitsDeducted,
+		InsufficientStockRejections,
+	)
+}
+
+// Handler serves Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Deducted counts quantity units deducted for tenantID.
+func Deducted(tenantID int64, quantity decimal.Decimal) {
+	UnitsDeducted.WithLabelValues(strconv.FormatInt(tenantID, 10)).Add(quantity.InexactFloat64())
+}
+
+// Rejected counts an order of tenantID rejected for insufficient stock.
+func Rejected(tenantID int64) {
+	InsufficientStockRejections.WithLabelValues(strconv.FormatInt(tenantID, 10)).Inc()
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"errors"
+
+	"atlasq/internal/apierr"
+	"atlasq/internal/correlation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorHandler answers every error returned by a handler with the apierr
+// envelope. Errors that are not an *apierr.Error become a 500 without their
+// text; RequestLogger has already logged them with the request.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	e := toAPIError(err)
+	return c.Status(e.Status).JSON(e.Body(correlation.ID(c.Context())))
+}
+
+func toAPIError(err error) *apierr.Error {
+	var apiErr *apierr.Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return apierr.New(fiberErr.Code, apierr.CodeFor(fiberErr.Code), fiberErr.Message)
+	}
+	return apierr.Internal("internal server error", err)
+}
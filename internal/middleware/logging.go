@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"time"
+
+	"atlasq/internal/correlation"
+	"atlasq/internal/logger"
+	"atlasq/internal/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestID accepts the client's X-Request-ID, or generates one when it is
+// missing or malformed, echoes it in the response and keeps it in the
+// request context for correlation.ID.
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(correlation.Header)
+		if !correlation.ValidID(id) {
+			id = utils.UUIDv4()
+		}
+		c.Set(correlation.Header, id)
+		c.Locals(correlation.Key, id)
+		return c.Next()
+	}
+}
+
+// RequestLogger writes one JSON line per request through logger.L(). Server
+// errors are logged at error level and client errors at warn level.
+func RequestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := responseStatus(c, err)
+
+		level := zapcore.InfoLevel
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			level = zapcore.ErrorLevel
+		case status >= fiber.StatusBadRequest:
+			level = zapcore.WarnLevel
+		}
+		ce := logger.L().Check(level, "request")
+		if ce == nil {
+			return err
+		}
+
+		fields := []zap.Field{
+			zap.String("method", c.Method()),
+			zap.String("route", c.Route().Path),
+			zap.String("path", c.Path()),
+			zap.Int("status", status),
+			zap.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			zap.String("tenant_id", c.Query("tenant")),
+			zap.String("request_id", correlation.ID(c.Context())),
+			zap.String("client_ip", c.IP()),
+		}
+		if sc := trace.SpanContextFromContext(tracing.Context(c.Context())); sc.IsValid() {
+			fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		}
+		ce.Write(fields...)
+		return err
+	}
+}
+
+// Logger returns logger.L() with the request and tenant IDs of c, for
+// handlers that log more than the request line.
+func Logger(c *fiber.Ctx) *zap.Logger {
+	return logger.L().With(
+		zap.String("request_id", correlation.ID(c.Context())),
+		zap.String("tenant_id", c.Query("tenant")),
+	)
+}
+
+// responseStatus is the status c will be answered with. A returned error is
+// turned into a response by the app's error handler after the middleware,
+// so the status comes from the error.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	return toAPIError(err).Status
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"atlasq/internal/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Metrics observes every request in metrics.HTTPRequestDuration.
+func Metrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Method(), c.Route().Path, strconv.Itoa(responseStatus(c, err))).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
@@ -0,0 +1,6 @@
+ALTER TABLE stock
+  DROP COLUMN IF EXISTS fixed_location_id,
+  DROP COLUMN IF EXISTS pick_strategy;
+
+DROP TABLE IF EXISTS stock_location;
+DROP TABLE IF EXISTS location;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS location (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  warehouse_id BIGINT NOT NULL,
+  parent_id BIGINT REFERENCES location (id),
+  type VARCHAR(10) NOT NULL,
+  code VARCHAR(50) NOT NULL,
+  status BOOLEAN NOT NULL DEFAULT true,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (type IN ('ZONE', 'AISLE', 'BIN')),
+  UNIQUE (tenant_id, warehouse_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS stock_location (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  stock_id BIGINT NOT NULL,
+  location_id BIGINT NOT NULL REFERENCES location (id),
+  quantity NUMERIC NOT NULL DEFAULT 0,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (quantity >= 0),
+  UNIQUE (stock_id, location_id)
+);
+
+ALTER TABLE stock
+  ADD COLUMN IF NOT EXISTS pick_strategy VARCHAR(20) NOT NULL DEFAULT 'LARGEST_FIRST',
+  ADD COLUMN IF NOT EXISTS fixed_location_id BIGINT REFERENCES location (id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_fulfillment;
+DROP TABLE IF EXISTS order_allocation;
+DROP TABLE IF EXISTS allocation_policy;
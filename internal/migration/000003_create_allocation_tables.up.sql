@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS allocation_policy (
+  tenant_id BIGINT PRIMARY KEY,
+  policy VARCHAR(20) NOT NULL DEFAULT 'MOST_STOCK',
+  warehouse_priority BIGINT[] NOT NULL DEFAULT '{}',
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (policy IN ('PRIORITY', 'MOST_STOCK', 'FEWEST_SPLITS'))
+);
+
+CREATE TABLE IF NOT EXISTS order_allocation (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  policy VARCHAR(20) NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS order_fulfillment (
+  id BIGSERIAL PRIMARY KEY,
+  allocation_id BIGINT NOT NULL REFERENCES order_allocation (id),
+  tenant_id BIGINT NOT NULL,
+  warehouse_id BIGINT NOT NULL,
+  product_id BIGINT NOT NULL,
+  quantity BIGINT NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS order_fulfillment_allocation_id_idx ON order_fulfillment (allocation_id);
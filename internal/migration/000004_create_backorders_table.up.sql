@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS backorder (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  warehouse_id BIGINT,
+  product_id BIGINT NOT NULL,
+  allocation_id BIGINT REFERENCES order_allocation (id),
+  quantity BIGINT NOT NULL,
+  filled BIGINT NOT NULL DEFAULT 0,
+  status VARCHAR(10) NOT NULL DEFAULT 'OPEN',
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (quantity > 0),
+  CHECK (filled >= 0 AND filled <= quantity),
+  CHECK (status IN ('OPEN', 'FILLED'))
+);
+
+CREATE INDEX IF NOT EXISTS backorder_open_idx ON backorder (tenant_id, product_id) WHERE status = 'OPEN';
@@ -0,0 +1 @@
+ALTER TABLE stock DROP COLUMN IF EXISTS source;
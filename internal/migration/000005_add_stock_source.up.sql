@@ -0,0 +1,28 @@
+ALTER TABLE stock ADD COLUMN IF NOT EXISTS source VARCHAR(10) NOT NULL DEFAULT 'RECEIPT';
+
+-- Order deduction used to insert a missing stock row seeded with the ordered
+-- quantity and deduct it straight back to zero, in one transaction. Such rows
+-- start their ledger with an ORDER entry whose change cancels the old quantity
+-- and that was written at the very time the row was created. Receipts of that
+-- era logged stock_id = 0, so a row with any receipt for its product and
+-- warehouse up to then was received, not seeded, and keeps RECEIPT. Rows this
+-- cannot tell apart stay RECEIPT, the report is best-effort for old data.
+UPDATE stock s SET source = 'ORDER'
+FROM (
+  SELECT DISTINCT ON (stock_id) id, stock_id, model, quantity_old, quantity_change, create_date
+  FROM transaction
+  WHERE stock_id IS NOT NULL AND stock_id <> 0
+  ORDER BY stock_id, id
+) first
+WHERE first.stock_id = s.id
+  AND first.model = 'ORDER'
+  AND first.quantity_old = -first.quantity_change
+  AND first.create_date = s.create_date
+  AND NOT EXISTS (
+    SELECT 1 FROM transaction r
+    WHERE r.teanant_id = s.tenant_id
+      AND r.product_id = s.product_id
+      AND r.warehouse_id = s.warehouse_id
+      AND r.model <> 'ORDER'
+      AND r.id < first.id
+  );
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS product_tenant_sku_key;
+
+UPDATE product p SET sku = c.sku
+FROM product_sku_conflict c
+WHERE c.product_id = p.id AND p.sku IS NULL;
+
+DROP TABLE IF EXISTS product_sku_conflict;
+
+ALTER TABLE product
+  DROP COLUMN IF EXISTS update_date,
+  DROP COLUMN IF EXISTS create_date,
+  DROP COLUMN IF EXISTS archived_date;
@@ -0,0 +1,31 @@
+ALTER TABLE product
+  ADD COLUMN IF NOT EXISTS archived_date TIMESTAMP NULL DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  ADD COLUMN IF NOT EXISTS update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- SKUs repeated within a tenant stay on the oldest product; the others lose
+-- theirs so the index below can be built. They are kept here to be fixed by
+-- hand.
+CREATE TABLE IF NOT EXISTS product_sku_conflict (
+  product_id BIGINT PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  sku TEXT NOT NULL,
+  kept_product_id BIGINT NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO product_sku_conflict (product_id, tenant_id, sku, kept_product_id)
+SELECT id, tenant_id, sku, kept_id
+FROM (
+  SELECT id, tenant_id, sku, MIN(id) OVER (PARTITION BY tenant_id, sku) AS kept_id
+  FROM product
+  WHERE sku IS NOT NULL AND sku <> ''
+) p
+WHERE id <> kept_id
+ON CONFLICT (product_id) DO NOTHING;
+
+UPDATE product SET sku = NULL
+WHERE id IN (SELECT product_id FROM product_sku_conflict);
+
+-- SKUs are optional, only non-empty ones must be unique per tenant
+CREATE UNIQUE INDEX IF NOT EXISTS product_tenant_sku_key ON product (tenant_id, sku) WHERE sku IS NOT NULL AND sku <> '';
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS product_variant_option;
+DROP TABLE IF EXISTS product_option;
+DROP INDEX IF EXISTS product_parent_id_idx;
+
+ALTER TABLE product
+  DROP COLUMN IF EXISTS price_override,
+  DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,24 @@
+ALTER TABLE product
+  ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES product (id),
+  ADD COLUMN IF NOT EXISTS price_override NUMERIC NULL DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS product_parent_id_idx ON product (parent_id) WHERE parent_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS product_option (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  name VARCHAR(50) NOT NULL,
+  position INT NOT NULL,
+  option_values TEXT[] NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (product_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS product_variant_option (
+  variant_id BIGINT NOT NULL REFERENCES product (id),
+  option_id BIGINT NOT NULL REFERENCES product_option (id),
+  value VARCHAR(50) NOT NULL,
+  PRIMARY KEY (variant_id, option_id)
+);
@@ -0,0 +1,7 @@
+ALTER TABLE order_fulfillment DROP COLUMN IF EXISTS bundle_product_id;
+ALTER TABLE transaction DROP COLUMN IF EXISTS bundle_product_id;
+
+DROP TABLE IF EXISTS bundle_component;
+
+ALTER TABLE product DROP CONSTRAINT IF EXISTS product_type_check;
+ALTER TABLE product DROP COLUMN IF EXISTS type;
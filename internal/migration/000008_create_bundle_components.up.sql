@@ -0,0 +1,19 @@
+ALTER TABLE product ADD COLUMN IF NOT EXISTS type VARCHAR(10) NOT NULL DEFAULT 'SIMPLE';
+ALTER TABLE product ADD CONSTRAINT product_type_check CHECK (type IN ('SIMPLE', 'BUNDLE'));
+
+CREATE TABLE IF NOT EXISTS bundle_component (
+  bundle_id BIGINT NOT NULL REFERENCES product (id),
+  component_id BIGINT NOT NULL REFERENCES product (id),
+  quantity BIGINT NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (bundle_id, component_id),
+  CHECK (quantity > 0),
+  CHECK (bundle_id <> component_id)
+);
+
+CREATE INDEX IF NOT EXISTS bundle_component_component_id_idx ON bundle_component (component_id);
+
+-- ledger and allocation rows for components shipped as part of a bundle line
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS bundle_product_id BIGINT NULL DEFAULT NULL;
+ALTER TABLE order_fulfillment ADD COLUMN IF NOT EXISTS bundle_product_id BIGINT NULL DEFAULT NULL;
@@ -0,0 +1,29 @@
+ALTER TABLE backorder
+  ALTER COLUMN filled TYPE BIGINT,
+  ALTER COLUMN quantity TYPE BIGINT;
+
+ALTER TABLE bundle_component ALTER COLUMN quantity TYPE BIGINT;
+ALTER TABLE order_fulfillment ALTER COLUMN quantity TYPE BIGINT;
+
+ALTER TABLE transaction
+  ALTER COLUMN quantity_old TYPE DOUBLE PRECISION,
+  ALTER COLUMN quantity_change TYPE DOUBLE PRECISION,
+  ALTER COLUMN quantity_new TYPE DOUBLE PRECISION,
+  ALTER COLUMN reserve_old TYPE DOUBLE PRECISION,
+  ALTER COLUMN reserve_change TYPE DOUBLE PRECISION,
+  ALTER COLUMN reserve_new TYPE DOUBLE PRECISION,
+  ALTER COLUMN on_hand_old TYPE DOUBLE PRECISION,
+  ALTER COLUMN on_hand_change TYPE DOUBLE PRECISION,
+  ALTER COLUMN on_hand_new TYPE DOUBLE PRECISION;
+
+ALTER TABLE stock_location ALTER COLUMN quantity TYPE NUMERIC;
+
+ALTER TABLE stock
+  ALTER COLUMN on_hand TYPE DOUBLE PRECISION,
+  ALTER COLUMN reserve TYPE DOUBLE PRECISION,
+  ALTER COLUMN quantity TYPE DOUBLE PRECISION,
+  ALTER COLUMN minimum TYPE DOUBLE PRECISION;
+
+ALTER TABLE product
+  ALTER COLUMN price_override TYPE NUMERIC,
+  ALTER COLUMN price TYPE DOUBLE PRECISION;
@@ -0,0 +1,31 @@
+-- Quantities and money are exact NUMERIC(20,4) values, matching
+-- numeric.QuantityScale and numeric.MoneyScale.
+ALTER TABLE product
+  ALTER COLUMN price TYPE NUMERIC(20,4),
+  ALTER COLUMN price_override TYPE NUMERIC(20,4);
+
+ALTER TABLE stock
+  ALTER COLUMN minimum TYPE NUMERIC(20,4),
+  ALTER COLUMN quantity TYPE NUMERIC(20,4),
+  ALTER COLUMN reserve TYPE NUMERIC(20,4),
+  ALTER COLUMN on_hand TYPE NUMERIC(20,4);
+
+ALTER TABLE stock_location ALTER COLUMN quantity TYPE NUMERIC(20,4);
+
+ALTER TABLE transaction
+  ALTER COLUMN quantity_old TYPE NUMERIC(20,4),
+  ALTER COLUMN quantity_change TYPE NUMERIC(20,4),
+  ALTER COLUMN quantity_new TYPE NUMERIC(20,4),
+  ALTER COLUMN reserve_old TYPE NUMERIC(20,4),
+  ALTER COLUMN reserve_change TYPE NUMERIC(20,4),
+  ALTER COLUMN reserve_new TYPE NUMERIC(20,4),
+  ALTER COLUMN on_hand_old TYPE NUMERIC(20,4),
+  ALTER COLUMN on_hand_change TYPE NUMERIC(20,4),
+  ALTER COLUMN on_hand_new TYPE NUMERIC(20,4);
+
+ALTER TABLE order_fulfillment ALTER COLUMN quantity TYPE NUMERIC(20,4);
+ALTER TABLE bundle_component ALTER COLUMN quantity TYPE NUMERIC(20,4);
+
+ALTER TABLE backorder
+  ALTER COLUMN quantity TYPE NUMERIC(20,4),
+  ALTER COLUMN filled TYPE NUMERIC(20,4);
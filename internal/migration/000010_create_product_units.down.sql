@@ -0,0 +1,7 @@
+ALTER TABLE transaction
+  DROP COLUMN IF EXISTS unit_factor,
+  DROP COLUMN IF EXISTS unit;
+
+DROP TABLE IF EXISTS product_unit;
+
+ALTER TABLE product DROP COLUMN IF EXISTS base_unit;
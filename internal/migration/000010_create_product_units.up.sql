@@ -0,0 +1,17 @@
+ALTER TABLE product ADD COLUMN IF NOT EXISTS base_unit VARCHAR(20) NOT NULL DEFAULT 'PCS';
+
+-- one row per alternative unit: 1 <code> = <factor> base units
+CREATE TABLE IF NOT EXISTS product_unit (
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  code VARCHAR(20) NOT NULL,
+  factor NUMERIC(20,4) NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (product_id, code),
+  CHECK (factor > 0)
+);
+
+-- the unit a ledger row was entered in; quantity_* columns stay in the base unit
+ALTER TABLE transaction
+  ADD COLUMN IF NOT EXISTS unit VARCHAR(20) NULL DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS unit_factor NUMERIC(20,4) NULL DEFAULT NULL;
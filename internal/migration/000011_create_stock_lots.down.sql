@@ -0,0 +1,3 @@
+ALTER TABLE transaction DROP COLUMN IF EXISTS lots;
+
+DROP TABLE IF EXISTS stock_lot;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS stock_lot (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  stock_id BIGINT NOT NULL,
+  lot_number VARCHAR(50) NOT NULL,
+  expiry_date DATE NULL DEFAULT NULL,
+  quantity NUMERIC(20,4) NOT NULL DEFAULT 0,
+  -- set by the daily expiry job once expiry_date is within the warning window
+  near_expiry_date TIMESTAMP NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (quantity >= 0),
+  UNIQUE (stock_id, lot_number)
+);
+
+CREATE INDEX IF NOT EXISTS stock_lot_expiry_date_idx ON stock_lot (expiry_date) WHERE quantity > 0;
+
+-- lots received or consumed by a ledger row, [{lot_id, lot_number, expiry_date, quantity}]
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS lots JSONB NULL DEFAULT NULL;
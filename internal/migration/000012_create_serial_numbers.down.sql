@@ -0,0 +1,5 @@
+ALTER TABLE transaction DROP COLUMN IF EXISTS serials;
+
+DROP TABLE IF EXISTS serial_number;
+
+ALTER TABLE product DROP COLUMN IF EXISTS serialized;
@@ -0,0 +1,22 @@
+ALTER TABLE product ADD COLUMN IF NOT EXISTS serialized BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS serial_number (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  -- the stock row that holds or last held the unit
+  stock_id BIGINT NOT NULL,
+  serial VARCHAR(100) NOT NULL,
+  status VARCHAR(10) NOT NULL DEFAULT 'IN_STOCK',
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (status IN ('IN_STOCK', 'RESERVED', 'SOLD', 'RETURNED')),
+  UNIQUE (tenant_id, product_id, serial)
+);
+
+CREATE INDEX IF NOT EXISTS serial_number_stock_id_idx ON serial_number (stock_id, status);
+
+-- serials received or issued by a ledger row
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS serials TEXT[] NULL DEFAULT NULL;
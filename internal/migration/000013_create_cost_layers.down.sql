@@ -0,0 +1,8 @@
+ALTER TABLE transaction
+  DROP COLUMN IF EXISTS total_cost,
+  DROP COLUMN IF EXISTS unit_cost;
+
+ALTER TABLE stock DROP COLUMN IF EXISTS value;
+
+DROP TABLE IF EXISTS cost_layer;
+DROP TABLE IF EXISTS costing_method;
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS costing_method (
+  tenant_id BIGINT PRIMARY KEY,
+  method VARCHAR(10) NOT NULL DEFAULT 'FIFO',
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (method IN ('FIFO', 'AVERAGE'))
+);
+
+-- FIFO layers, one per receipt, consumed oldest first
+CREATE TABLE IF NOT EXISTS cost_layer (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  stock_id BIGINT NOT NULL,
+  quantity NUMERIC(20,4) NOT NULL,
+  remaining NUMERIC(20,4) NOT NULL,
+  unit_cost NUMERIC(20,4) NOT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (remaining >= 0 AND remaining <= quantity),
+  CHECK (unit_cost >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS cost_layer_stock_id_idx ON cost_layer (stock_id, id) WHERE remaining > 0;
+
+-- value of the quantity on hand under the tenant's costing method
+ALTER TABLE stock ADD COLUMN IF NOT EXISTS value NUMERIC(20,4) NOT NULL DEFAULT 0;
+
+-- cost of a ledger row, signed like quantity_change
+ALTER TABLE transaction
+  ADD COLUMN IF NOT EXISTS unit_cost NUMERIC(20,4) NULL DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS total_cost NUMERIC(20,4) NULL DEFAULT NULL;
+
+-- stock received before costing has no known cost
+INSERT INTO cost_layer (tenant_id, stock_id, quantity, remaining, unit_cost)
+SELECT tenant_id, id, quantity, quantity, 0 FROM stock WHERE quantity > 0;
@@ -0,0 +1,22 @@
+-- low-stock alerts, opened when a stock row falls to its minimum
+CREATE TABLE IF NOT EXISTS stock_alert (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  stock_id BIGINT NOT NULL,
+  product_id BIGINT NOT NULL,
+  warehouse_id BIGINT NOT NULL,
+  minimum NUMERIC(20,4) NOT NULL,
+  quantity NUMERIC(20,4) NOT NULL,
+  delivered_date TIMESTAMP NULL DEFAULT NULL,
+  delivery_attempts INT NOT NULL DEFAULT 0,
+  resolved_date TIMESTAMP NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- one open alert per stock row until it is restocked above its minimum
+CREATE UNIQUE INDEX IF NOT EXISTS stock_alert_open_idx ON stock_alert (stock_id) WHERE resolved_date IS NULL;
+CREATE INDEX IF NOT EXISTS stock_alert_tenant_id_idx ON stock_alert (tenant_id, id);
+CREATE INDEX IF NOT EXISTS stock_alert_undelivered_idx ON stock_alert (id) WHERE delivered_date IS NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE transaction DROP COLUMN IF EXISTS purchase_order_id;
+
+DROP TABLE IF EXISTS goods_receipt_line;
+DROP TABLE IF EXISTS goods_receipt;
+DROP TABLE IF EXISTS purchase_order_line;
+DROP TABLE IF EXISTS purchase_order;
+DROP TABLE IF EXISTS supplier;
@@ -0,0 +1,82 @@
+CREATE TABLE IF NOT EXISTS supplier (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  name VARCHAR(200) NOT NULL,
+  email VARCHAR(255) NULL DEFAULT NULL,
+  phone VARCHAR(50) NULL DEFAULT NULL,
+  archived_date TIMESTAMP NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (tenant_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  supplier_id BIGINT NOT NULL REFERENCES supplier (id),
+  warehouse_id BIGINT NOT NULL,
+  reference VARCHAR(100) NULL DEFAULT NULL,
+  status VARCHAR(10) NOT NULL DEFAULT 'OPEN',
+  -- lines may be received this many percent over or under the ordered quantity
+  tolerance_percent NUMERIC(7,4) NOT NULL DEFAULT 0,
+  expected_date DATE NULL DEFAULT NULL,
+  closed_date TIMESTAMP NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (status IN ('OPEN', 'PARTIAL', 'RECEIVED', 'CLOSED')),
+  CHECK (tolerance_percent >= 0 AND tolerance_percent <= 100)
+);
+
+CREATE INDEX IF NOT EXISTS purchase_order_tenant_id_idx ON purchase_order (tenant_id, status);
+
+-- quantities and costs are in the product's base unit
+CREATE TABLE IF NOT EXISTS purchase_order_line (
+  id BIGSERIAL PRIMARY KEY,
+  purchase_order_id BIGINT NOT NULL REFERENCES purchase_order (id) ON DELETE CASCADE,
+  product_id BIGINT NOT NULL REFERENCES product (id),
+  quantity NUMERIC(20,4) NOT NULL,
+  received NUMERIC(20,4) NOT NULL DEFAULT 0,
+  unit_cost NUMERIC(20,4) NOT NULL DEFAULT 0,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (quantity > 0),
+  CHECK (received >= 0),
+  CHECK (unit_cost >= 0),
+  UNIQUE (purchase_order_id, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS goods_receipt (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  purchase_order_id BIGINT NOT NULL REFERENCES purchase_order (id),
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS goods_receipt_purchase_order_id_idx ON goods_receipt (purchase_order_id);
+
+CREATE TABLE IF NOT EXISTS goods_receipt_line (
+  id BIGSERIAL PRIMARY KEY,
+  goods_receipt_id BIGINT NOT NULL REFERENCES goods_receipt (id) ON DELETE CASCADE,
+  purchase_order_line_id BIGINT NOT NULL REFERENCES purchase_order_line (id),
+  quantity NUMERIC(20,4) NOT NULL,
+  unit_cost NUMERIC(20,4) NOT NULL,
+  lot_number VARCHAR(50) NULL DEFAULT NULL,
+  serials TEXT[] NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (quantity > 0)
+);
+
+-- the purchase order a RECEIVE ledger row was received against
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS purchase_order_id BIGINT NULL DEFAULT NULL;
@@ -0,0 +1,9 @@
+ALTER TABLE transaction
+  DROP COLUMN IF EXISTS stock_count_id,
+  DROP COLUMN IF EXISTS approved_by,
+  DROP COLUMN IF EXISTS reason;
+
+ALTER TABLE stock DROP COLUMN IF EXISTS frozen_count_id;
+
+DROP TABLE IF EXISTS stock_count_line;
+DROP TABLE IF EXISTS stock_count;
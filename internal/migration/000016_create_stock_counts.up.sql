@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS stock_count (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  warehouse_id BIGINT NOT NULL,
+  status VARCHAR(10) NOT NULL DEFAULT 'OPEN',
+  -- frozen counts block stock changes of their products until posted or cancelled
+  freeze BOOLEAN NOT NULL DEFAULT false,
+  approved_by VARCHAR(100) NULL DEFAULT NULL,
+  posted_date TIMESTAMP NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (status IN ('OPEN', 'POSTED', 'CANCELLED'))
+);
+
+CREATE INDEX IF NOT EXISTS stock_count_tenant_id_idx ON stock_count (tenant_id, status);
+
+-- expected is the stock quantity when the count was opened
+CREATE TABLE IF NOT EXISTS stock_count_line (
+  id BIGSERIAL PRIMARY KEY,
+  stock_count_id BIGINT NOT NULL REFERENCES stock_count (id) ON DELETE CASCADE,
+  stock_id BIGINT NOT NULL,
+  product_id BIGINT NOT NULL,
+  expected NUMERIC(20,4) NOT NULL,
+  counted NUMERIC(20,4) NULL DEFAULT NULL,
+  reason VARCHAR(20) NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_update_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CHECK (counted IS NULL OR counted >= 0),
+  CHECK (reason IS NULL OR reason IN ('DAMAGE', 'SHRINKAGE', 'FOUND')),
+  UNIQUE (stock_count_id, product_id)
+);
+
+-- the open count freezing a stock row, if any
+ALTER TABLE stock ADD COLUMN IF NOT EXISTS frozen_count_id BIGINT NULL DEFAULT NULL;
+
+ALTER TABLE transaction
+  ADD COLUMN IF NOT EXISTS reason VARCHAR(20) NULL DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS approved_by VARCHAR(100) NULL DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS stock_count_id BIGINT NULL DEFAULT NULL;
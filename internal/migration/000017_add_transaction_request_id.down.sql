@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS transaction_request_id_idx;
+
+ALTER TABLE transaction DROP COLUMN IF EXISTS request_id;
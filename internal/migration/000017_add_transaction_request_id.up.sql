@@ -0,0 +1,4 @@
+-- X-Request-ID of the HTTP request that caused the ledger row
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS request_id VARCHAR(64) NULL DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS transaction_request_id_idx ON transaction (request_id) WHERE request_id IS NOT NULL;
@@ -0,0 +1,17 @@
+-- changes operators make through admin tools such as the /monitor dashboard
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  actor VARCHAR(100) NOT NULL,
+  role VARCHAR(20) NOT NULL,
+  method VARCHAR(10) NOT NULL,
+  path VARCHAR(1000) NOT NULL,
+  status INT NOT NULL,
+  -- request body, cut to 4 KB
+  details TEXT NULL DEFAULT NULL,
+  request_id VARCHAR(64) NULL DEFAULT NULL,
+  client_ip VARCHAR(45) NULL DEFAULT NULL,
+  create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  row_create_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_create_date_idx ON audit_log (create_date);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS serial_number_reserved_by_idx;
+ALTER TABLE serial_number DROP COLUMN IF EXISTS reserved_by;
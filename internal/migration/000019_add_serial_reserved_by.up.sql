@@ -0,0 +1,9 @@
+-- the queued order holding a RESERVED serial, only its task may sell it
+ALTER TABLE serial_number ADD COLUMN IF NOT EXISTS reserved_by VARCHAR(64) NULL DEFAULT NULL;
+
+-- reservations made before this column have no owner; back in stock they can
+-- still be sold by the task that named them
+UPDATE serial_number SET status = 'IN_STOCK', update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP
+WHERE status = 'RESERVED';
+
+CREATE INDEX IF NOT EXISTS serial_number_reserved_by_idx ON serial_number (tenant_id, reserved_by) WHERE reserved_by IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE stock_alert DROP COLUMN IF EXISTS claimed_until;
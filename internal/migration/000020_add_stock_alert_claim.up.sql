@@ -0,0 +1,3 @@
+-- an alert being delivered is claimed by one worker until then, so
+-- overlapping runs do not post it twice; a crashed run's claim runs out
+ALTER TABLE stock_alert ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP NULL DEFAULT NULL;
@@ -2,45 +2,64 @@ package migration
 
 import (
 	"atlasq/internal/database"
-	"fmt"
+	"atlasq/internal/logger"
+	"context"
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
 )
 
+// files are built into the binaries so the migrator and the readiness
+// checks always agree on the schema version.
+//
+//go:embed *.sql
+var files embed.FS
+
 type Migrate struct {
 	Db *database.PostgreSQL
 }
 
-func (m *Migrate) migrationPath() string {
-	return "file:///Users/rosemary/projects/gosaas/atlasq/internal/migration"
+func (m *Migrate) migrate() (*migrate.Migrate, error) {
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithSourceInstance("iofs", src, m.Db.ConnectionURI())
 }
 
 func (m *Migrate) MigrateUp() error {
-
-	fmt.Println("Begin migration...")
-
-	mig, err := migrate.New(m.migrationPath(), m.Db.ConnectionURI())
-
+	mig, err := m.migrate()
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(mig.Version())
-
-	fmt.Println("Starting migration...")
+	version, dirty, err := mig.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	log := logger.L().With(zap.Uint("from_version", version), zap.Bool("dirty", dirty))
+	log.Info("starting migration")
 
 	err = mig.Up()
 	if err != nil && err != migrate.ErrNoChange {
-		fmt.Println("End with ERROR -> ", err)
+		log.Error("migration failed", zap.Error(err))
 		return err
 	}
 
-	fmt.Println("End with no ERROR!")
+	version, _, _ = mig.Version()
+	log.Info("migration done", zap.Uint("version", version))
 	return nil
 }
 
 func (m *Migrate) MigrateDown() error {
-	mig, err := migrate.New(m.migrationPath(), m.Db.ConnectionURI())
+	mig, err := m.migrate()
 	if err != nil {
 		return err
 	}
@@ -50,3 +69,41 @@ func (m *Migrate) MigrateDown() error {
 	}
 	return nil
 }
+
+// Latest is the version of the newest embedded migration.
+func Latest() (uint, error) {
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// Current returns the schema version recorded in schema_migrations, which
+// is dirty when a migration failed halfway.
+func Current(ctx context.Context, pool *pgxpool.Pool) (version uint, dirty bool, err error) {
+	var v int64
+	err = pool.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(v), dirty, nil
+}
@@ -0,0 +1,24 @@
+package numeric
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+// Scales of the NUMERIC(20,4) columns that hold quantities and money.
+const (
+	QuantityScale = 4
+	MoneyScale    = 4
+)
+
+var ErrTooPrecise = errors.New("has too many decimal places")
+
+// CheckScale rejects values with more than scale decimal places instead of
+// silently rounding them.
+func CheckScale(d decimal.Decimal, scale int32) error {
+	if !d.Equal(d.Truncate(scale)) {
+		return ErrTooPrecise
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Drainer shuts the app down without cutting off requests in flight.
+// fasthttp cancels every request context as soon as its Shutdown starts,
+// which would roll back open transactions, so Drainer first stops accepting
+// connections and waits for the running requests itself.
+type Drainer struct {
+	active   atomic.Int64
+	draining atomic.Bool
+}
+
+// Middleware counts requests in flight. Once draining, responses close
+// their keep-alive connection so clients reconnect to the new process.
+func (d *Drainer) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		d.active.Add(1)
+		defer d.active.Add(-1)
+		err := c.Next()
+		if d.draining.Load() {
+			c.Context().SetConnectionClose()
+		}
+		return err
+	}
+}
+
+// Shutdown closes ln, waits up to timeout for the requests in flight to
+// finish and then shuts app down, cancelling whatever is still running.
+func (d *Drainer) Shutdown(app *fiber.App, ln net.Listener, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	d.draining.Store(true)
+	if err := ln.Close(); err != nil {
+		return err
+	}
+	for d.active.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return app.ShutdownWithTimeout(time.Until(deadline))
+}
+
+// Draining reports whether Shutdown has started.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// Active is the number of requests in flight.
+func (d *Drainer) Active() int64 {
+	return d.active.Load()
+}
@@ -0,0 +1,34 @@
+// Package server holds the API's listener and graceful shutdown.
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Listen listens on addr with SO_REUSEPORT where the platform has it, so a
+// new process can bind the port while the old one drains; see the restart
+// in .github/workflows/deploy.yml. The listener may be closed more than
+// once.
+func Listen(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: reusePort}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &onceCloseListener{Listener: ln}, nil
+}
+
+// onceCloseListener lets Drainer close the listener ahead of fasthttp's
+// Shutdown, which gives up when closing it again fails.
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() { l.err = l.Listener.Close() })
+	return l.err
+}
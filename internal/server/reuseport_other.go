@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package server
+
+import "syscall"
+
+// reusePort is a no-op where SO_REUSEPORT is not available; restarts then
+// have to wait for the old process to release the port.
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return nil
+}
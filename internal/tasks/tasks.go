@@ -1,20 +1,38 @@
 package tasks
 
+import "github.com/shopspring/decimal"
+
 // ข้อมูลของแต่ละ item ที่อยู่ใน order
+// Unit is optional; it defaults to the product's base unit
+// Serials optionally names the units of a serialized product, one per unit
 type OrderItem struct {
-	ProductID int64 `json:"product_id"`
-	Quantity  int64 `json:"quantity"`
+	ProductID int64           `json:"product_id" validate:"required"`
+	Quantity  decimal.Decimal `json:"quantity" validate:"gt=0,scale=4"`
+	Unit      string          `json:"unit,omitempty" validate:"max=20"`
+	Serials   []string        `json:"serials,omitempty"`
 }
 
 // Payload ที่ใช้ส่งเข้า queue
+// WarehouseID = 0 means the worker allocates the order over the tenant's warehouses
+// RequestID is the X-Request-ID of the HTTP request that enqueued the task
+// TraceContext carries the enqueuing span to the worker, see tracing.TaskMiddleware
+// ReservationID holds the serials named on the items until the worker sells or releases them
 type DeductStockPayload struct {
-	TenantID    int64       `json:"tenant_id"`
-	WarehouseID int64       `json:"warehouse_id"`
-	Items       []OrderItem `json:"items"`
+	TenantID        int64             `json:"tenant_id"`
+	WarehouseID     int64             `json:"warehouse_id"`
+	Items           []OrderItem       `json:"items"`
+	FulfillmentMode string            `json:"fulfillment_mode"`
+	RequestID       string            `json:"request_id,omitempty"`
+	TraceContext    map[string]string `json:"trace_context,omitempty"`
+	ReservationID   string            `json:"reservation_id,omitempty"`
 }
 
 // Request body ที่ client จะส่งเข้ามาที่ API
+// warehouse_id is optional; omit it to let the allocation policy pick warehouses
+// fulfillment_mode is ALL_OR_NOTHING (default), FILL_AVAILABLE or BACKORDER
+// items name each product once, at most 100 of them
 type OrderRequest struct {
-	WarehouseID int64       `json:"warehouse_id"`
-	Items       []OrderItem `json:"items"`
+	WarehouseID     int64       `json:"warehouse_id"`
+	Items           []OrderItem `json:"items" validate:"required,min=1,max=100,unique=ProductID,dive"`
+	FulfillmentMode string      `json:"fulfillment_mode" validate:"omitempty,oneof=ALL_OR_NOTHING FILL_AVAILABLE BACKORDER"`
 }
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var messagingSystem = semconv.MessagingSystemKey.String("asynq")
+
+// StartEnqueue starts the producer span of enqueueing a task of taskType.
+// The trace context of the returned context belongs in the task payload's
+// trace_context so TaskMiddleware can continue the trace.
+func StartEnqueue(ctx context.Context, taskType string) (context.Context, trace.Span) {
+	return Tracer().Start(Context(ctx), taskType+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingSystem, semconv.MessagingDestinationName(taskType)),
+	)
+}
+
+// TaskMiddleware runs every task in a consumer span. Tasks whose JSON
+// payload has a trace_context continue the trace of the request that
+// enqueued them.
+func TaskMiddleware(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		var envelope struct {
+			TraceContext map[string]string `json:"trace_context"`
+		}
+		// scheduled tasks have no payload and start a new trace
+		_ = json.Unmarshal(t.Payload(), &envelope)
+		ctx = Extract(ctx, envelope.TraceContext)
+
+		taskID, _ := asynq.GetTaskID(ctx)
+		queue, _ := asynq.GetQueueName(ctx)
+		retry, _ := asynq.GetRetryCount(ctx)
+		ctx, span := Tracer().Start(ctx, t.Type()+" process",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				messagingSystem,
+				semconv.MessagingDestinationName(queue),
+				semconv.MessagingMessageID(taskID),
+				attribute.String("asynq.task_type", t.Type()),
+				attribute.Int("asynq.retry_count", retry),
+			),
+		)
+		defer span.End()
+
+		err := h.ProcessTask(ctx, t)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
+}
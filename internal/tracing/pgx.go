@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryLogger turns pgx's query log into spans. pgx v4 has no tracer hook,
+// but it logs every Query, Exec and batch with its duration once it is
+// done, on the context the query ran with. Queries outside a traced request
+// or task are not recorded. Arguments are never put on spans.
+type QueryLogger struct{}
+
+func (QueryLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	elapsed, ok := data["time"].(time.Duration)
+	if !ok {
+		return
+	}
+	ctx = Context(ctx)
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+
+	end := time.Now()
+	sql, _ := data["sql"].(string)
+	operation := msg
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	_, span := Tracer().Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-elapsed)),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+		),
+	)
+	if sql != "" {
+		span.SetAttributes(semconv.DBQueryText(sql))
+	}
+	if err, ok := data["err"].(error); ok {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,115 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace context
+// across the API, the asynq queue and PostgreSQL.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultEndpoint is the OTLP gRPC port of the Data Prepper otel-traces
+// pipeline in opensearch/docker-compose.yml. OTEL_EXPORTER_OTLP_ENDPOINT
+// overrides it.
+const DefaultEndpoint = "localhost:21890"
+
+const tracerName = "atlasq"
+
+type Config struct {
+	Service string
+	// Exporter receives finished spans. Nil exports them in batches over
+	// OTLP gRPC to Endpoint. A given exporter gets every span as it ends, so
+	// tests can pass tracetest.NewInMemoryExporter() and read it right away.
+	Exporter sdktrace.SpanExporter
+	// Endpoint is the host:port of the OTLP gRPC receiver. Empty uses
+	// OTEL_EXPORTER_OTLP_ENDPOINT, or DefaultEndpoint when that is unset.
+	Endpoint string
+}
+
+// Init installs the global tracer provider and W3C trace context
+// propagator. The returned function flushes and stops the provider.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	processor := sdktrace.WithSyncer(cfg.Exporter)
+	if cfg.Exporter == nil {
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		} else if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(DefaultEndpoint))
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.WithBatcher(exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.Service),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer of the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+type spanKey struct{}
+
+// SpanKey is where the tracing middleware keeps the request span. Fiber
+// keeps c.Locals on the fasthttp request context, so handlers passing
+// c.Context() down still carry it; Context turns it into a parent span.
+var SpanKey any = spanKey{}
+
+// Context returns ctx with the span the tracing middleware stored in it as
+// its current span, so spans started from c.Context() nest under the
+// request span.
+func Context(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	if span, ok := ctx.Value(SpanKey).(trace.Span); ok {
+		return trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+// Inject returns the trace context of ctx as a map, for task payloads.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(Context(ctx), carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx continuing the trace context Inject put in carrier.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
@@ -0,0 +1,155 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"atlasq/internal/middleware"
+	"atlasq/internal/tracing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setup(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := tracing.Init(context.Background(), tracing.Config{Service: "atlasq-test", Exporter: exporter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+	return exporter
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	names := make([]string, 0, len(spans))
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	t.Fatalf("no span %q among %v", name, names)
+	return tracetest.SpanStub{}
+}
+
+// TestRequestToTask follows one order from the HTTP request through the
+// queue: the handler queries PostgreSQL and enqueues a task, which the
+// worker processes on the same trace.
+func TestRequestToTask(t *testing.T) {
+	exporter := setup(t)
+
+	var payload []byte
+	app := fiber.New()
+	app.Use(middleware.Tracing())
+	app.Post("/api/v1/orders/:id", func(c *fiber.Ctx) error {
+		// what pgx reports once a query on the request context is done
+		tracing.QueryLogger{}.Log(c.Context(), pgx.LogLevelInfo, "Query", map[string]interface{}{
+			"sql":  "SELECT id FROM stock WHERE product_id = $1",
+			"time": 2 * time.Millisecond,
+		})
+
+		ctx, span := tracing.StartEnqueue(c.Context(), "order:deduct_stock")
+		defer span.End()
+		var err error
+		payload, err = json.Marshal(map[string]any{"trace_context": tracing.Inject(ctx)})
+		if err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusAccepted)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/api/v1/orders/7", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	processed := false
+	handler := tracing.TaskMiddleware(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		processed = trace.SpanContextFromContext(ctx).IsValid()
+		return nil
+	}))
+	if err := handler.ProcessTask(context.Background(), asynq.NewTask("order:deduct_stock", payload)); err != nil {
+		t.Fatal(err)
+	}
+	if !processed {
+		t.Fatal("task ran without a span")
+	}
+
+	spans := exporter.GetSpans()
+	server := findSpan(t, spans, "POST /api/v1/orders/:id")
+	query := findSpan(t, spans, "SELECT")
+	publish := findSpan(t, spans, "order:deduct_stock publish")
+	process := findSpan(t, spans, "order:deduct_stock process")
+
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span kind = %v", server.SpanKind)
+	}
+	if server.Parent.IsValid() {
+		t.Errorf("server span has a parent %v", server.Parent.SpanID())
+	}
+	tests := []struct {
+		name   string
+		span   tracetest.SpanStub
+		parent tracetest.SpanStub
+		kind   trace.SpanKind
+	}{
+		{"query", query, server, trace.SpanKindClient},
+		{"publish", publish, server, trace.SpanKindProducer},
+		{"process", process, publish, trace.SpanKindConsumer},
+	}
+	for _, tt := range tests {
+		if tt.span.SpanKind != tt.kind {
+			t.Errorf("%s span kind = %v, want %v", tt.name, tt.span.SpanKind, tt.kind)
+		}
+		if tt.span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("%s span is on trace %v, want %v", tt.name, tt.span.SpanContext.TraceID(), server.SpanContext.TraceID())
+		}
+		if tt.span.Parent.SpanID() != tt.parent.SpanContext.SpanID() {
+			t.Errorf("%s span parent = %v, want %s", tt.name, tt.span.Parent.SpanID(), tt.parent.Name)
+		}
+	}
+	if !process.Parent.IsRemote() {
+		t.Error("process span parent is not remote, the trace context did not go through the payload")
+	}
+}
+
+func TestQueryLoggerNeedsATrace(t *testing.T) {
+	exporter := setup(t)
+
+	tracing.QueryLogger{}.Log(context.Background(), pgx.LogLevelInfo, "Query", map[string]interface{}{
+		"sql":  "SELECT 1",
+		"time": time.Millisecond,
+	})
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("untraced query recorded %d spans", len(spans))
+	}
+}
+
+func TestTaskWithoutTraceContextStartsATrace(t *testing.T) {
+	exporter := setup(t)
+
+	handler := tracing.TaskMiddleware(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		return nil
+	}))
+	if err := handler.ProcessTask(context.Background(), asynq.NewTask("lot:flag_expiring", nil)); err != nil {
+		t.Fatal(err)
+	}
+	process := findSpan(t, exporter.GetSpans(), "lot:flag_expiring process")
+	if process.Parent.IsValid() {
+		t.Errorf("scheduled task span has a parent %v", process.Parent.SpanID())
+	}
+}
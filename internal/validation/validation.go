@@ -0,0 +1,180 @@
+// Package validation enforces the `validate` struct tags of request bodies
+// (see github.com/go-playground/validator) and reports every failing field
+// in the details of an apierr.Error:
+//
+//	{"error": {"code": "invalid_request", "message": "request validation failed",
+//	  "details": {"fields": [{"field": "items[0].quantity", "rule": "gt", "message": "must be greater than 0"}]}}}
+//
+// Decimal fields are compared as numbers, so gt=0 and gte=0 work on them,
+// and the scale=N rule limits their decimal places.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"atlasq/internal/apierr"
+	"atlasq/internal/numeric"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+)
+
+// FieldError is one failing rule of a request field. Field is the JSON path
+// of the field, such as items[0].quantity.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterCustomTypeFunc(func(field reflect.Value) any {
+		d := field.Interface().(decimal.Decimal)
+		f, _ := d.Float64()
+		return f
+	}, decimal.Decimal{})
+	if err := v.RegisterValidation("scale", checkScale); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// checkScale is scale=N. The field has already been turned into a float64
+// by the decimal type func, which can round, so the decimal itself is read
+// from the parent struct.
+func checkScale(fl validator.FieldLevel) bool {
+	var scale int32
+	if _, err := fmt.Sscan(fl.Param(), &scale); err != nil {
+		panic(fmt.Sprintf("validation: bad scale %q", fl.Param()))
+	}
+	field := reflect.Indirect(fl.Parent()).FieldByName(fl.StructFieldName())
+	if field.Kind() == reflect.Pointer {
+		field = field.Elem()
+	}
+	d, ok := field.Interface().(decimal.Decimal)
+	if !ok {
+		panic("validation: scale only applies to decimals")
+	}
+	return numeric.CheckScale(d, scale) == nil
+}
+
+// Struct validates v against its tags. The error is an *apierr.Error that
+// lists every failing field.
+func Struct(v any) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return apierr.Internal("failed to validate request", err)
+	}
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{Field: fieldPath(fe), Rule: fe.Tag(), Message: message(fe)})
+	}
+	return apierr.BadRequest("request validation failed").With("fields", fields)
+}
+
+// Body parses the request body into v and validates it.
+func Body(c *fiber.Ctx, v any) error {
+	if err := c.BodyParser(v); err != nil {
+		return apierr.BadRequest("invalid request body")
+	}
+	return Struct(v)
+}
+
+// fieldPath drops the struct name the namespace starts with.
+func fieldPath(fe validator.FieldError) string {
+	_, path, ok := strings.Cut(fe.Namespace(), ".")
+	if !ok {
+		return fe.Field()
+	}
+	return path
+}
+
+func message(fe validator.FieldError) string {
+	countable := false
+	switch fe.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		countable = true
+	}
+	switch fe.Tag() {
+	case "required", "required_if", "required_with":
+		return "is required"
+	case "max":
+		if countable {
+			return "must have at most " + fe.Param() + " " + unit(fe)
+		}
+		return "must be at most " + fe.Param()
+	case "min":
+		if countable {
+			return "must have at least " + fe.Param() + " " + unit(fe)
+		}
+		return "must be at least " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	case "lte":
+		return "must be less than or equal to " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "scale":
+		return "must have at most " + fe.Param() + " decimal places"
+	case "unique":
+		if fe.Param() != "" {
+			return "must not repeat " + jsonName(fe.Param())
+		}
+		return "must not repeat values"
+	case "nefield":
+		return "must differ from " + jsonName(fe.Param())
+	case "email":
+		return "must be an email address"
+	case "datetime":
+		if fe.Param() == "2006-01-02" {
+			return "must be a date in YYYY-MM-DD format"
+		}
+		return "must match the layout " + fe.Param()
+	}
+	return "is invalid"
+}
+
+func unit(fe validator.FieldError) string {
+	u := "item"
+	if fe.Kind() == reflect.String {
+		u = "character"
+	}
+	if fe.Param() != "1" {
+		u += "s"
+	}
+	return u
+}
+
+// jsonName turns the Go field name of a rule parameter, such as
+// FromLocationID, into its JSON name.
+func jsonName(field string) string {
+	var b strings.Builder
+	for i, r := range field {
+		if unicode.IsUpper(r) && i > 0 && unicode.IsLower(rune(field[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
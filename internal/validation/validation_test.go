@@ -0,0 +1,136 @@
+package validation
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"atlasq/internal/apierr"
+	tasks "atlasq/internal/tasks"
+
+	"github.com/shopspring/decimal"
+)
+
+type moveRequest struct {
+	FromLocationID int64            `json:"from_location_id" validate:"required"`
+	ToLocationID   int64            `json:"to_location_id" validate:"required,nefield=FromLocationID"`
+	Quantity       decimal.Decimal  `json:"quantity" validate:"gt=0,scale=4"`
+	UnitCost       *decimal.Decimal `json:"unit_cost" validate:"omitempty,gte=0,scale=2"`
+	Note           string           `json:"note" validate:"max=5"`
+	Date           string           `json:"date" validate:"omitempty,datetime=2006-01-02"`
+	Email          string           `json:"email" validate:"omitempty,email"`
+	Internal       string           `json:"-" validate:"max=1"`
+}
+
+func TestStruct(t *testing.T) {
+	dec := decimal.RequireFromString
+	cost := dec("1.005")
+	valid := moveRequest{FromLocationID: 1, ToLocationID: 2, Quantity: dec("1.5")}
+	tests := []struct {
+		name string
+		req  any
+		want []FieldError
+	}{
+		{name: "valid", req: valid},
+		{
+			name: "missing fields",
+			req:  moveRequest{Quantity: dec("1")},
+			want: []FieldError{
+				{"from_location_id", "required", "is required"},
+				{"to_location_id", "required", "is required"},
+			},
+		},
+		{
+			name: "decimal rules",
+			req:  moveRequest{FromLocationID: 1, ToLocationID: 2, Quantity: dec("0.00001"), UnitCost: &cost},
+			want: []FieldError{
+				{"quantity", "scale", "must have at most 4 decimal places"},
+				{"unit_cost", "scale", "must have at most 2 decimal places"},
+			},
+		},
+		{
+			name: "zero is not greater than 0",
+			req:  moveRequest{FromLocationID: 1, ToLocationID: 2},
+			want: []FieldError{{"quantity", "gt", "must be greater than 0"}},
+		},
+		{
+			name: "field comparisons and formats",
+			req:  moveRequest{FromLocationID: 1, ToLocationID: 1, Quantity: dec("1"), Note: "too long", Date: "01/02/2024", Email: "nobody"},
+			want: []FieldError{
+				{"to_location_id", "nefield", "must differ from from_location_id"},
+				{"note", "max", "must have at most 5 characters"},
+				{"date", "datetime", "must be a date in YYYY-MM-DD format"},
+				{"email", "email", "must be an email address"},
+			},
+		},
+		{
+			name: "fields without a JSON name use the Go name",
+			req:  moveRequest{FromLocationID: 1, ToLocationID: 2, Quantity: dec("1"), Internal: "xx"},
+			want: []FieldError{{"Internal", "max", "must have at most 1 character"}},
+		},
+		{
+			name: "order without items",
+			req:  tasks.OrderRequest{},
+			want: []FieldError{{"items", "required", "is required"}},
+		},
+		{
+			name: "order items are checked one by one",
+			req: tasks.OrderRequest{
+				Items: []tasks.OrderItem{
+					{ProductID: 1, Quantity: dec("1")},
+					{Quantity: dec("-1")},
+				},
+				FulfillmentMode: "SOMETIMES",
+			},
+			want: []FieldError{
+				{"items[1].product_id", "required", "is required"},
+				{"items[1].quantity", "gt", "must be greater than 0"},
+				{"fulfillment_mode", "oneof", "must be one of ALL_OR_NOTHING, FILL_AVAILABLE, BACKORDER"},
+			},
+		},
+		{
+			name: "repeated products",
+			req: tasks.OrderRequest{Items: []tasks.OrderItem{
+				{ProductID: 1, Quantity: dec("1")},
+				{ProductID: 1, Quantity: dec("2")},
+			}},
+			want: []FieldError{{"items", "unique", "must not repeat product_id"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Struct(tt.req)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Struct = %v, want nil", err)
+				}
+				return
+			}
+			var apiErr *apierr.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Struct = %v, want an *apierr.Error", err)
+			}
+			if apiErr.Status != http.StatusBadRequest || apiErr.Code != apierr.CodeInvalidRequest {
+				t.Errorf("status %d code %q, want 400 %q", apiErr.Status, apiErr.Code, apierr.CodeInvalidRequest)
+			}
+			if got := apiErr.Details["fields"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONName(t *testing.T) {
+	tests := map[string]string{
+		"ProductID":      "product_id",
+		"FromLocationID": "from_location_id",
+		"Name":           "name",
+		"SKU":            "sku",
+	}
+	for field, want := range tests {
+		if got := jsonName(field); got != want {
+			t.Errorf("jsonName(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
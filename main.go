@@ -1,32 +1,64 @@
 package main
 
 import (
+	"atlasq/internal/admin"
+	"atlasq/internal/apierr"
+	"atlasq/internal/correlation"
 	"atlasq/internal/database"
+	"atlasq/internal/handler"
+	"atlasq/internal/health"
+	"atlasq/internal/inventory"
+	"atlasq/internal/metrics"
+	"atlasq/internal/numeric"
+	"atlasq/internal/server"
 	tasks "atlasq/internal/tasks"
+	"atlasq/internal/tracing"
+	"atlasq/internal/validation"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/hibiken/asynq"
-	"github.com/hibiken/asynqmon"
 	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
 
 	"atlasq/internal/logger"
+	"atlasq/internal/middleware"
 
+	"go.opentelemetry.io/otel/codes"
 	"go.uber.org/zap"
 )
 
+// shutdownTimeout bounds how long a SIGTERM waits for requests in flight.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	if err := logger.Init("atlasq", logger.ComponentAPI); err != nil {
+		log.Fatalf("failed to init logger: %v", err)
+	}
+	defer logger.L().Sync()
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{Service: "atlasq"})
+	if err != nil {
+		logger.L().Fatal("failed to init tracing", zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
+
 	db := &database.PostgreSQL{}
 
 	// Connect to PostgreSQL
 	pool, err := db.Connect()
 	if err != nil {
-		log.Fatalf("failed to connect to PostgreSQL : %v", err)
+		logger.L().Fatal("failed to connect to PostgreSQL", zap.Error(err))
 	}
 	defer pool.Close()
 
@@ -34,28 +66,47 @@ func main() {
 	client := asynq.NewClient(asynq.RedisClientOpt{Addr: "127.0.0.1:6379"})
 	defer client.Close()
 
-	log.Println("Connected to PostgreSQL successfully")
+	logger.L().Info("connected to PostgreSQL")
 
 	_ = asynq.NewClient(asynq.RedisClientOpt{Addr: "127.0.0.1:6379"})
 
-	app := fiber.New()
-
-	app.Use(func(c *fiber.Ctx) error {
-		start := time.Now()
-		err := c.Next()
-		elapsed := time.Since(start)
-		log.Printf("[%s] %s took %s", c.Method(), c.Path(), elapsed)
-		return err
-	})
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+
+	// counts requests in flight so shutdown can wait for them
+	drainer := &server.Drainer{}
+	app.Use(drainer.Middleware())
+
+	// probes come before the request middleware so they are not logged,
+	// traced or counted on every poll
+	healthChecks := &handler.Health{Checks: []health.Check{
+		health.Postgres(pool),
+		health.Redis(client),
+		health.Schema(pool),
+	}, Draining: drainer.Draining}
+	healthChecks.Register(app)
+
+	// one JSON log line per request, tagged with its X-Request-ID
+	app.Use(middleware.RequestID())
+	app.Use(middleware.Tracing())
+	app.Use(middleware.Metrics())
+	app.Use(middleware.RequestLogger())
+
+	if err := metrics.RegisterPool(pool); err != nil {
+		logger.L().Fatal("failed to register pool metrics", zap.Error(err))
+	}
+	app.Get("/metrics", adaptor.HTTPHandler(metrics.Handler()))
 
-	// Asynqmon Web UI
-	r := asynqmon.New(asynqmon.Options{
-		RootPath:     "/monitor",
-		RedisConnOpt: asynq.RedisClientOpt{Addr: "127.0.0.1:6379"},
-	})
+	// runtime log level, needs the ADMIN_TOKEN bearer token
+	app.All("/admin/log-level", adaptor.HTTPHandler(admin.RequireToken(logger.LevelHandler())))
 
-	// ใช้ adaptor.WrapHandler / HTTPHandler เพื่อแปลงให้ Fiber ใช้ได้
-	app.Use("/monitor", adaptor.HTTPHandler(r))
+	// Asynqmon Web UI behind ADMIN_USER/ADMIN_PASSWORD, MONITOR_READ_ONLY=true
+	// takes the write actions away from admins too
+	monitor := &handler.Monitor{
+		Pool:     pool,
+		Redis:    asynq.RedisClientOpt{Addr: "127.0.0.1:6379"},
+		ReadOnly: os.Getenv("MONITOR_READ_ONLY") == "true",
+	}
+	monitor.Register(app)
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("AtlasQ")
@@ -69,31 +120,19 @@ func main() {
 
 		conn, err := pool.Acquire(c.Context())
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to acquire database connection",
-			})
+			return apierr.Internal("failed to acquire database connection", err)
 		}
 		defer conn.Release()
 
 		var req TenantRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "invalid request body",
-			})
-		}
-		if len(req.Name) == 0 || len(req.Name) > 255 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "name is required and must be <= 255 characters",
-			})
+		if err := validation.Body(c, &req); err != nil {
+			return err
 		}
 		// TODO: insert tenant to database here
 		_, err = conn.Exec(c.Context(), `INSERT INTO public.tenant (name) VALUES ($1)`, req.Name)
 
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to insert tenant",
-				"test":  err.Error(),
-			})
+			return apierr.Internal("failed to insert tenant", err)
 		}
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -102,179 +141,182 @@ func main() {
 		})
 	})
 
-	type ProductRequest struct {
-		Name        string  `json:"name" validate:"required,max=255"`
-		Description string  `json:"description"`
-		Price       float64 `json:"price" validate:"required,gte=0"`
-		SKU         string  `json:"sku"`
+	type StockRequest struct {
+		ProductID   int64            `json:"product_id" validate:"required"`
+		WarehouseID int64            `json:"warehouse_id" validate:"required"`
+		Quantity    decimal.Decimal  `json:"quantity" validate:"required,scale=4"`                  // จำนวนที่เพิ่ม (+) หรือ ลด (-)
+		Unit        string           `json:"unit" validate:"max=20"`                                // optional, defaults to the product's base unit
+		LotNumber   string           `json:"lot_number" validate:"required_with=ExpiryDate,max=50"` // optional, lot received into or taken from
+		ExpiryDate  string           `json:"expiry_date" validate:"omitempty,datetime=2006-01-02"`  // optional, YYYY-MM-DD, with lot_number only
+		Serials     []string         `json:"serials"`                                               // one per unit for serialized products
+		UnitCost    *decimal.Decimal `json:"unit_cost" validate:"omitempty,gte=0,scale=4"`          // optional, cost per unit entered, receipts only
 	}
 
-	app.Post("/api/v1/products", func(c *fiber.Ctx) error {
+	app.Post("/api/v1/stocks", func(c *fiber.Ctx) error {
 
 		conn, err := pool.Acquire(c.Context())
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to acquire database connection",
-			})
+			return apierr.Internal("failed to acquire database connection", err)
 		}
 		defer conn.Release()
 
-		tenantID := c.Query("tenant")
-		if tenantID == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "tenant query string is required",
-			})
+		tenantIDStr := c.Query("tenant")
+		if tenantIDStr == "" {
+			return apierr.BadRequest("tenant query string is required")
 		}
 
-		// Validate tenant exists
-		var exists bool
-		err = conn.QueryRow(
-			c.Context(),
-			`SELECT EXISTS(SELECT 1 FROM tenant WHERE id = $1)`, tenantID,
-		).Scan(&exists)
+		tenantID, err := strconv.ParseInt(tenantIDStr, 10, 64)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to validate tenant",
-			})
-		}
-		if !exists {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "tenant not found",
-			})
+			return apierr.BadRequest("invalid tenant ID")
 		}
 
-		var req ProductRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": err.Error(),
-			})
+		var req StockRequest
+		if err := validation.Body(c, &req); err != nil {
+			return err
 		}
-		if len(req.Name) == 0 || len(req.Name) > 255 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "name is required and must be <= 255 characters",
-			})
+		if req.UnitCost != nil && !req.Quantity.IsPositive() {
+			return apierr.BadRequest("unit_cost only goes with receipts")
 		}
-		if req.Price == 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "price is required and must be > 0",
-			})
+		var expiryDate *time.Time
+		if req.ExpiryDate != "" {
+			d, _ := time.Parse("2006-01-02", req.ExpiryDate)
+			expiryDate = &d
 		}
 
-		_, err = conn.Exec(
-			c.Context(),
-			`INSERT INTO product (tenant_id, name, description, price, sku) VALUES ($1, $2, $3, $4, $5)`,
-			tenantID, req.Name, req.Description, req.Price, req.SKU,
-		)
+		tx, err := conn.Begin(c.Context())
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   "failed to insert product",
-				"message": err.Error(),
-			})
+			return apierr.Internal("failed to start transaction", err)
 		}
+		defer tx.Rollback(c.Context())
 
-		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-			"message": "Product created",
-			"name":    req.Name,
-		})
-	})
-
-	type StockRequest struct {
-		ProductID   int64 `json:"product_id"`
-		WarehouseID int64 `json:"warehouse_id"`
-		Quantity    int64 `json:"quantity"` // จำนวนที่เพิ่ม (+) หรือ ลด (-)
-	}
-
-	app.Post("/api/v1/stocks", func(c *fiber.Ctx) error {
-
-		conn, err := pool.Acquire(c.Context())
+		// stock is kept in the product's base unit, the ledger keeps the unit entered
+		units, err := inventory.LoadUnits(c.Context(), tx, tenantID, []int64{req.ProductID})
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to acquire database connection",
-			})
+			return apierr.Internal("failed to load units", err)
+		}
+		entered, err := inventory.ToBase([]tasks.OrderItem{{ProductID: req.ProductID, Quantity: req.Quantity, Unit: req.Unit}}, units)
+		if err != nil {
+			return handler.InventoryError(err, "failed to convert units")
+		}
+		req.Quantity, req.Unit = entered[0].Quantity, entered[0].Unit
+		var unit *string
+		var unitFactor *decimal.Decimal
+		if req.Unit != "" {
+			factor, _ := units[req.ProductID].Factor(req.Unit)
+			unit, unitFactor = &req.Unit, &factor
+			if req.UnitCost != nil {
+				baseCost := req.UnitCost.Div(factor).Round(numeric.MoneyScale)
+				req.UnitCost = &baseCost
+			}
 		}
-		defer conn.Release()
 
-		tenantID := c.Query("tenant")
-		if tenantID == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "tenant query string is required",
+		// receipts create the stock row, lots, serials, cost layers and
+		// ledger row in one place, shared with goods receipts
+		if req.Quantity.IsPositive() {
+			receipt := inventory.Receipt{
+				ProductID:   req.ProductID,
+				WarehouseID: req.WarehouseID,
+				Quantity:    req.Quantity,
+				Unit:        req.Unit,
+				UnitCost:    req.UnitCost,
+				LotNumber:   req.LotNumber,
+				ExpiryDate:  expiryDate,
+				Serials:     req.Serials,
+			}
+			if unitFactor != nil {
+				receipt.UnitFactor = *unitFactor
+			}
+			result, err := inventory.ReceiveStock(c.Context(), tx, tenantID, receipt)
+			if err != nil {
+				return handler.InventoryError(err, "failed to receive stock")
+			}
+			if err := tx.Commit(c.Context()); err != nil {
+				return apierr.Internal("failed to commit transaction", err)
+			}
+			return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+				"message":           "Stock updated",
+				"currentStock":      result.Quantity,
+				"backorders_filled": result.BackordersFilled,
+				"unit_cost":         result.Cost.UnitCost,
+				"total_cost":        result.Cost.Total,
 			})
 		}
+		issued := req.Quantity.Neg()
 
-		var req StockRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "invalid request body",
-			})
+		var stockID int64
+		var oldStock decimal.Decimal
+		err = tx.QueryRow(
+			c.Context(),
+			`SELECT id, quantity FROM stock WHERE product_id = $1 AND warehouse_id = $2 AND tenant_id = $3 FOR UPDATE`,
+			req.ProductID, req.WarehouseID, tenantID,
+		).Scan(&stockID, &oldStock)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return handler.InventoryError(&inventory.InsufficientStockError{ProductID: req.ProductID, Stock: decimal.Zero, Required: issued}, "")
+		}
+		if err != nil {
+			return apierr.Internal("failed to load stock", err)
+		}
+		// a frozen row only changes when its count is posted
+		if err := inventory.CheckFrozen(c.Context(), tx, stockID, req.ProductID); err != nil {
+			return handler.InventoryError(err, "failed to load stock")
+		}
+		currentStock := oldStock.Sub(issued)
+		if currentStock.IsNegative() {
+			return handler.InventoryError(&inventory.InsufficientStockError{ProductID: req.ProductID, Stock: oldStock, Required: issued}, "")
+		}
+		// deductions must also leave the bins, otherwise they would hold more than the warehouse total
+		if err := inventory.PickLocations(c.Context(), tx, stockID, issued); err != nil {
+			return apierr.Internal("failed to update stock locations", err)
 		}
 
-		if req.ProductID == 0 || req.WarehouseID == 0 || req.Quantity == 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "product_id, warehouse_id, and quantity are required",
-			})
+		// deductions take the named lot or pick FEFO
+		var picks []inventory.LotPick
+		if req.LotNumber != "" {
+			var pick *inventory.LotPick
+			pick, err = inventory.TakeLot(c.Context(), tx, stockID, req.LotNumber, issued)
+			if pick != nil {
+				picks = append(picks, *pick)
+			}
+		} else {
+			picks, err = inventory.PickLots(c.Context(), tx, stockID, issued)
+		}
+		if err != nil {
+			return handler.InventoryError(err, "failed to update lots")
+		}
+		lots, err := inventory.LotsJSON(picks)
+		if err != nil {
+			return apierr.Internal("failed to update lots", err)
 		}
 
-		tx, err := conn.Begin(c.Context())
+		// serialized products issue one serial per unit
+		serials, err := inventory.TakeSerials(c.Context(), tx, tenantID, req.ProductID, stockID, issued, req.Serials)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to start transaction",
-			})
+			return handler.InventoryError(err, "failed to update serials")
 		}
-		defer tx.Rollback(c.Context())
 
-		var currentStock int64
-		err = tx.QueryRow(
+		// issues are costed by the tenant's method
+		cost, err := inventory.IssueCost(c.Context(), tx, tenantID, stockID, oldStock, issued)
+		if err != nil {
+			return apierr.Internal("failed to update stock value", err)
+		}
+
+		_, err = tx.Exec(
 			c.Context(),
-			`SELECT quantity FROM stock WHERE product_id = $1 AND warehouse_id = $2 AND tenant_id = $3`,
-			req.ProductID, req.WarehouseID, tenantID,
-		).Scan(&currentStock)
-
-		if err != nil { // ไม่เจอ stock
-			_, err := tx.Exec(
-				c.Context(),
-				`INSERT INTO stock (
-					tenant_id, warehouse_id, product_id,
-					minimum, quantity, reserve, on_hand, status,
-					create_date, update_date, row_create_date, row_update_date
-				) VALUES (
-					$1, $2, $3,
-					0, $4, 0, $4, true,
-					CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
-				)`,
-				req.ProductID, req.WarehouseID, tenantID, req.Quantity,
-			)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "failed to create stock",
-				})
-			}
-			currentStock = req.Quantity
-		} else {
-			newStock := currentStock + req.Quantity
-			if newStock < 0 {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error":         "not enough stock to deduct",
-					"current_stock": currentStock,
-					"deduct":        req.Quantity,
-				})
-			}
-			_, err := tx.Exec(
-				c.Context(),
-				`UPDATE stock SET
-					quantity = $1,
-					on_hand = $1,
-					update_date = CURRENT_TIMESTAMP,
-					row_update_date = CURRENT_TIMESTAMP
-				WHERE product_id = $2 AND warehouse_id = $3 AND tenant_id = $4`,
-				newStock, req.ProductID, req.WarehouseID, tenantID,
-			)
-			if err != nil {
-				fmt.Println("failed to update stock")
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "failed to update stock",
-				})
-			}
-			currentStock = newStock
+			`UPDATE stock SET
+				quantity = $1,
+				on_hand = $1,
+				update_date = CURRENT_TIMESTAMP,
+				row_update_date = CURRENT_TIMESTAMP
+			WHERE id = $2`,
+			currentStock, stockID,
+		)
+		if err != nil {
+			return apierr.Internal("failed to update stock", err)
+		}
+
+		// falling to the minimum opens a low-stock alert
+		if err := inventory.CheckLowStock(c.Context(), tx, stockID); err != nil {
+			return apierr.Internal("failed to check low stock", err)
 		}
 
 		_, err = tx.Exec(
@@ -284,75 +326,59 @@ func main() {
                 quantity_old, quantity_change, quantity_new,
                 reserve_old, reserve_change, reserve_new,
                 on_hand_old, on_hand_change, on_hand_new,
-                status, create_date, update_date, row_create_date, row_update_date
+                status, unit, unit_factor, lots, serials, unit_cost, total_cost, request_id, create_date, update_date, row_create_date, row_update_date
             ) VALUES (
                 $1, $2, $3, $4, $5, $6,
                 $7, $8, $9,
                 $10, $11, $12,
                 $13, $14, $15,
-                $16, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+                $16, $17, $18, $19, $20, $21, $22, NULLIF($23, ''), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
             )`,
-			"STOCK", "ISSUE", tenantID, req.ProductID, req.WarehouseID, 0, // stock_id = 0 ถ้าไม่มี
-			currentStock, req.Quantity, currentStock+req.Quantity,
+			"STOCK", "ISSUE", tenantID, req.ProductID, req.WarehouseID, stockID,
+			oldStock, req.Quantity, currentStock,
 			0, 0, 0, // reserve
-			0, 0, 0, // on_hand
-			true,
+			oldStock, req.Quantity, currentStock,
+			true, unit, unitFactor, lots, serials, cost.UnitCost, cost.Total, correlation.ID(c.Context()),
 		)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to create transaction log",
-			})
+			return apierr.Internal("failed to create transaction log", err)
 		}
 
 		if err := tx.Commit(c.Context()); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to commit transaction",
-			})
+			return apierr.Internal("failed to commit transaction", err)
 		}
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-			"message":      "Stock updated",
-			"currentStock": currentStock,
+			"message":           "Stock updated",
+			"currentStock":      currentStock,
+			"backorders_filled": decimal.Zero,
+			"unit_cost":         cost.UnitCost,
+			"total_cost":        cost.Total,
 		})
 	})
 
-	type OrderItem struct {
-		ProductID int64 `json:"product_id"`
-		Quantity  int64 `json:"quantity"`
-	}
-
-	type OrderRequest struct {
-		WarehouseID int64       `json:"warehouse_id"`
-		Items       []OrderItem `json:"items"`
-	}
-
 	app.Post("/api/v1/orders", func(c *fiber.Ctx) error {
 
 		conn, err := pool.Acquire(c.Context())
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to acquire database connection",
-			})
+			return apierr.Internal("failed to acquire database connection", err)
 		}
 		defer conn.Release()
 
-		tenantID := c.Query("tenant")
-		if tenantID == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "tenant query string is required",
-			})
+		tenantIDStr := c.Query("tenant")
+		if tenantIDStr == "" {
+			return apierr.BadRequest("tenant query string is required")
 		}
 
-		var req OrderRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "invalid request body",
-			})
+		tenantID, err := strconv.ParseInt(tenantIDStr, 10, 64)
+		if err != nil {
+			return apierr.BadRequest("invalid tenant ID")
 		}
-		if req.WarehouseID == 0 || len(req.Items) == 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "warehouse_id and items are required",
-			})
+
+		// warehouse_id is optional: without it the order is allocated over warehouses
+		var req tasks.OrderRequest
+		if err := validation.Body(c, &req); err != nil {
+			return err
 		}
 
 		/*
@@ -380,123 +406,28 @@ func main() {
 			IsoLevel: pgx.RepeatableRead, // หรือ pgx.Serializable
 		})
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"message": err.Error(),
-				"error":   "failed to start transaction",
-			})
+			return apierr.Internal("failed to start transaction", err)
 		}
 		defer tx.Rollback(c.Context())
 
-		for _, item := range req.Items {
-			var stockQty, reserveQty, onHandQty float64
-			var stockID int64
-
-			// หา stock
-			err := tx.QueryRow(
-				c.Context(),
-				`SELECT id, quantity, reserve, on_hand FROM stock WHERE product_id = $1 AND warehouse_id = $2 AND tenant_id = $3`,
-				item.ProductID, req.WarehouseID, tenantID,
-			).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
-
-			if err != nil { // ไม่เจอ stock
-				// สร้าง stock ใหม่
-				err = tx.QueryRow(
-					c.Context(),
-					`INSERT INTO stock (
-                        tenant_id, warehouse_id, product_id,
-                        minimum, quantity, reserve, on_hand, status,
-                        create_date, update_date, row_create_date, row_update_date
-                    ) VALUES (
-                        $1, $2, $3,
-                        0, $4, 0, $4, true,
-                        CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
-                    ) RETURNING id, quantity, reserve, on_hand`,
-					tenantID, req.WarehouseID, item.ProductID, item.Quantity,
-				).Scan(&stockID, &stockQty, &reserveQty, &onHandQty)
-				if err != nil {
-					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-						"error":   "failed to create stock",
-						"message": err.Error(),
-					})
-				}
-			}
-
-			// เช็ค stock พอไหม
-			if stockQty < float64(item.Quantity) {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error":      "not enough stock",
-					"product_id": item.ProductID,
-					"stock":      stockQty,
-					"required":   item.Quantity,
-				})
-			}
-
-			// ลด stock
-			newQty := stockQty - float64(item.Quantity)
-			_, err = tx.Exec(
-				c.Context(),
-				`UPDATE stock SET quantity = $1, on_hand = $1, update_date = CURRENT_TIMESTAMP, row_update_date = CURRENT_TIMESTAMP WHERE id = $2`,
-				newQty, stockID,
-			)
-			if err != nil {
-				fmt.Println("111 err", err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "111 failed to update stock",
-				})
-			}
-
-			// // สร้าง order item
-			// _, err = tx.Exec(
-			// 	c.Context(),
-			// 	`INSERT INTO order_items (product_id, quantity, warehouse_id, tenant_id) VALUES ($1, $2, $3, $4)`,
-			// 	item.ProductID, item.Quantity, req.WarehouseID, tenantID,
-			// )
-			// if err != nil {
-			// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			// 		"error":   "failed to create order item",
-			// 		"message": err.Error(),
-			// 	})
-			// }
-
-			// สร้าง transaction log
-			_, err = tx.Exec(
-				c.Context(),
-				`INSERT INTO transaction (
-                    model, event, teanant_id, product_id, warehouse_id, stock_id,
-                    quantity_old, quantity_change, quantity_new,
-                    reserve_old, reserve_change, reserve_new,
-                    on_hand_old, on_hand_change, on_hand_new,
-                    status, create_date, update_date, row_create_date, row_update_date
-                ) VALUES (
-                    $1, $2, $3, $4, $5, $6,
-                    $7, $8, $9,
-                    $10, $11, $12,
-                    $13, $14, $15,
-                    $16, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
-                )`,
-				"ORDER", "ISSUE", tenantID, item.ProductID, req.WarehouseID, stockID,
-				stockQty, -float64(item.Quantity), newQty,
-				reserveQty, 0, reserveQty,
-				onHandQty, -float64(item.Quantity), newQty,
-				true,
-			)
-			if err != nil {
-				fmt.Println("222 err", err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "failed to create transaction log",
-				})
+		result, err := inventory.FulfillOrder(c.Context(), tx, tenantID, req.WarehouseID, req.Items, req.FulfillmentMode)
+		if err != nil {
+			var stockErr *inventory.InsufficientStockError
+			if errors.As(err, &stockErr) {
+				metrics.Rejected(tenantID)
 			}
+			return handler.InventoryError(err, "failed to deduct stock")
 		}
 
 		if err := tx.Commit(c.Context()); err != nil {
-			fmt.Println("222 err", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "failed to commit transaction",
-			})
+			return apierr.Internal("failed to commit transaction", err)
 		}
+		metrics.Deducted(tenantID, result.Deducted)
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-			"message": "Order created",
+			"message":    "Order created",
+			"allocation": result.Allocation,
+			"shortages":  result.Shortages,
 		})
 	})
 
@@ -504,51 +435,136 @@ func main() {
 	app.Post("/api/v1/orders-queue", func(c *fiber.Ctx) error {
 		tenantIDStr := c.Query("tenant")
 		if tenantIDStr == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "tenant query string is required"})
+			return apierr.BadRequest("tenant query string is required")
 		}
 
 		tenantID, err := strconv.ParseInt(tenantIDStr, 10, 64)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid tenant ID"})
+			return apierr.BadRequest("invalid tenant ID")
 		}
 
 		var req tasks.OrderRequest
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		if err := validation.Body(c, &req); err != nil {
+			return err
 		}
 
-		if req.WarehouseID == 0 || len(req.Items) == 0 {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "warehouse_id and items are required"})
-		}
+		// the worker continues this trace from the payload's trace_context
+		enqueueCtx, span := tracing.StartEnqueue(c.Context(), "order:deduct_stock")
+		defer span.End()
 
 		payload := tasks.DeductStockPayload{
-			TenantID:    tenantID,
-			WarehouseID: req.WarehouseID,
-			Items:       req.Items,
+			TenantID:        tenantID,
+			WarehouseID:     req.WarehouseID,
+			Items:           req.Items,
+			FulfillmentMode: req.FulfillmentMode,
+			RequestID:       correlation.ID(c.Context()),
+			TraceContext:    tracing.Inject(enqueueCtx),
+			ReservationID:   utils.UUIDv4(),
 		}
 
 		data, err := json.Marshal(payload)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create task payload"})
+			return apierr.Internal("failed to create task payload", err)
+		}
+
+		// named serials are held until the worker sells them; the reservation
+		// is committed first so the task always sees it
+		tx, err := pool.Begin(c.Context())
+		if err != nil {
+			return apierr.Internal("failed to start transaction", err)
+		}
+		defer tx.Rollback(c.Context())
+		if err := inventory.ReserveSerials(c.Context(), tx, tenantID, payload.ReservationID, req.Items); err != nil {
+			return handler.InventoryError(err, "failed to reserve serials")
+		}
+		if err := tx.Commit(c.Context()); err != nil {
+			return apierr.Internal("failed to reserve serials", err)
 		}
 
 		task := asynq.NewTask("order:deduct_stock", data)
-		if _, err := client.Enqueue(task); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to enqueue task"})
+		if _, err := client.EnqueueContext(enqueueCtx, task); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			// no task will sell the serials, put them back
+			if releaseErr := inventory.ReleaseSerials(c.Context(), pool, tenantID, payload.ReservationID); releaseErr != nil {
+				middleware.Logger(c).Error("failed to release serials", zap.Error(releaseErr))
+			}
+			return apierr.Internal("failed to enqueue task", err)
 		}
 
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Order enqueued for processing"})
 	})
 
-	if err := app.Listen(":8080"); err != nil {
-		log.Fatalf("failed to start Fiber app: %v", err)
-	}
+	// Product catalog
+	products := &handler.Product{Pool: pool}
+	products.Register(app)
+
+	// Bin/location-level inventory
+	locations := &handler.Location{Pool: pool}
+	locations.Register(app)
+
+	// Multi-warehouse allocation policy and results
+	allocations := &handler.Allocation{Pool: pool}
+	allocations.Register(app)
+
+	// Backorders waiting for receipts
+	backorders := &handler.Backorder{Pool: pool}
+	backorders.Register(app)
+
+	// Reports
+	reports := &handler.Report{Pool: pool}
+	reports.Register(app)
+
+	// Lots and expiry dates
+	lots := &handler.Lot{Pool: pool}
+	lots.Register(app)
 
-	_ = logger.Init("atlasq")
-	logger.L().Info("worker started", zap.String("component", "job-consumer"))
+	// Serial numbers
+	serials := &handler.Serial{Pool: pool}
+	serials.Register(app)
 
-	logger.WithJob("12345", "high", "tiktok").Info("job processed",
-		zap.Int("duration_ms", 128),
-		zap.String("status", "success"),
-	)
+	// Costing method, valuation and COGS reports live under Report
+	costing := &handler.Costing{Pool: pool}
+	costing.Register(app)
+
+	// Stock minimums and low-stock alerts
+	alerts := &handler.Alert{Pool: pool}
+	alerts.Register(app)
+
+	// Suppliers, purchase orders and goods receipts
+	suppliers := &handler.Supplier{Pool: pool}
+	suppliers.Register(app)
+	purchaseOrders := &handler.PurchaseOrder{Pool: pool}
+	purchaseOrders.Register(app)
+
+	// Cycle counts and ADJUST postings
+	stockCounts := &handler.StockCount{Pool: pool}
+	stockCounts.Register(app)
+
+	ln, err := server.Listen(":8080")
+	if err != nil {
+		logger.L().Fatal("failed to listen", zap.Error(err))
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- app.Listener(ln)
+	}()
+
+	// SIGINT/SIGTERM stop new connections and let requests in flight finish
+	// before the deferred closes of the pool and the asynq client run
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		logger.L().Error("failed to serve Fiber app", zap.Error(err))
+		return
+	case <-ctx.Done():
+	}
+
+	logger.L().Info("shutting down", zap.Int64("in_flight", drainer.Active()), zap.Duration("timeout", shutdownTimeout))
+	if err := drainer.Shutdown(app, ln, shutdownTimeout); err != nil {
+		logger.L().Error("shutdown did not finish cleanly", zap.Int64("in_flight", drainer.Active()), zap.Error(err))
+		return
+	}
+	logger.L().Info("server stopped")
 }